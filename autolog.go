@@ -1,206 +1,1523 @@
 package autolog
 
 import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/mattn/go-isatty"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog/pkgerrors"
 )
 
 const (
-	LogLevelVarName      = "LOG_LEVEL"
-	LogColorVarName      = "LOG_COLOR"
-	LogOutputVarName     = "LOG_OUTPUT"
-	LogFormatVarName     = "LOG_FORMAT"
-	LogTimeFormatVarName = "LOG_TIMEFORMAT"
+	LogLevelVarName             = "LOG_LEVEL"
+	LogColorVarName             = "LOG_COLOR"
+	LogOutputVarName            = "LOG_OUTPUT"
+	LogFormatVarName            = "LOG_FORMAT"
+	LogTimeFormatVarName        = "LOG_TIMEFORMAT"
+	LogCallerVarName            = "LOG_CALLER"
+	LogMetaVarName              = "LOG_META"
+	LogStackVarName             = "LOG_STACK"
+	LogKeySanitizeVarName       = "LOG_KEY_SANITIZE"
+	LogSampleVarName            = "LOG_SAMPLE"
+	LogStrictVarName            = "LOG_STRICT"
+	LogConsoleSortFieldsVarName = "LOG_CONSOLE_SORT_FIELDS"
+	LogExistingVarName          = "LOG_EXISTING"
+	LogFieldTimeVarName         = "LOG_FIELD_TIME"
+	LogFieldLevelVarName        = "LOG_FIELD_LEVEL"
+	LogFieldMessageVarName      = "LOG_FIELD_MESSAGE"
+	LogConfigVarName            = "LOG_CONFIG"
+	LogFileModeVarName          = "LOG_FILEMODE"
+	LogMkdirVarName             = "LOG_MKDIR"
+	LogDirModeVarName           = "LOG_DIRMODE"
+	LogErrorOutputVarName       = "LOG_ERROR_OUTPUT"
+	LogErrorLevelVarName        = "LOG_ERROR_LEVEL"
+	LogPIDVarName               = "LOG_PID"
+	LogDedupVarName             = "LOG_DEDUP"
+	LogTimeResolutionVarName    = "LOG_TIME_RESOLUTION"
+	LogUTCVarName               = "LOG_UTC"
+	LogNDJSONVarName            = "LOG_NDJSON"
+	LogLevelNamesVarName        = "LOG_LEVEL_NAMES"
+	LogFieldOrderVarName        = "LOG_FIELD_ORDER"
+	LogSeqVarName               = "LOG_SEQ"
+	LogFSyncVarName             = "LOG_FSYNC"
 )
 
+// defaultConsoleTimeFormat applies to console output when LOG_TIMEFORMAT is
+// unset.  JSON output keeps Unix milliseconds for machine parsing.
+const defaultConsoleTimeFormat = "kitchen.ms"
+
+// logTimeFormatMap holds the aliases that take no precision suffix.
 var logTimeFormatMap = map[string]string{
-	"kitchen":    "3:04PM",
-	"kitchen.s":  "3:04:05PM",
-	"kitchen.ms": "3:04:05.999PM",
-	"kitchen.us": "3:04:05.999999PM",
-	"kitchen.ns": "3:04:05.999999999PM",
-	"rfc822":     "02 Jan 2006 15:04 -0700",
-	"rfc822.s":   "02 Jan 2006 15:04:05 -0700",
-	"rfc822.ms":  "02 Jan 2006 15:04:05.999 -0700",
-	"rfc822.us":  "02 Jan 2006 15:04:05.999999 -0700",
-	"rfc822.ns":  "02 Jan 2006 15:04:05.999999999 -0700",
-	"rfc1123":    "Mon, 02 Jan 2006 15:04 -0700",
-	"rfc1123.s":  "Mon, 02 Jan 2006 15:04:05 -0700",
-	"rfc1123.ms": "Mon, 02 Jan 2006 15:04:05.999 -0700",
-	"rfc1123.us": "Mon, 02 Jan 2006 15:04:05.999999 -0700",
-	"rfc1123.ns": "Mon, 02 Jan 2006 15:04:05.999999999 -0700",
-	"rfc3339":    "2006-01-02T15:04Z07:00",
-	"rfc3339.s":  "2006-01-02T15:04:05Z07:00",
-	"rfc3339.ms": "2006-01-02T15:04:05.999Z07:00",
-	"rfc3339.us": "2006-01-02T15:04:05.999999Z07:00",
-	"rfc3339.ns": "2006-01-02T15:04:05.999999999Z07:00",
-	"iso8601":    "2006-01-02T15:04Z07:00",
-	"iso8601.s":  "2006-01-02T15:04:05Z07:00",
-	"iso8601.ms": "2006-01-02T15:04:05.999Z07:00",
-	"iso8601.us": "2006-01-02T15:04:05.999999Z07:00",
-	"iso8601.ns": "2006-01-02T15:04:05.999999999Z07:00",
+	"date":   time.UnixDate,
+	"unix":   zerolog.TimeFormatUnix,
+	"unixms": zerolog.TimeFormatUnixMs,
+	"unixus": zerolog.TimeFormatUnixMicro,
+	"unixns": zerolog.TimeFormatUnixNano,
+}
+
+// logTimeResolutionMap maps LOG_TIME_RESOLUTION values to zerolog's Unix
+// time formats.
+var logTimeResolutionMap = map[string]string{
+	"":   zerolog.TimeFormatUnixMs,
+	"s":  zerolog.TimeFormatUnix,
+	"ms": zerolog.TimeFormatUnixMs,
+	"us": zerolog.TimeFormatUnixMicro,
+	"ns": zerolog.TimeFormatUnixNano,
+}
+
+// logTimeFormatBases holds the aliases that accept a precision suffix, as in
+// "rfc3339.ms".  Each layout is split at the seconds position.
+var logTimeFormatBases = map[string][2]string{
+	"kitchen": {"3:04", "PM"},
+	"rfc822":  {"02 Jan 2006 15:04", " -0700"},
+	"rfc1123": {"Mon, 02 Jan 2006 15:04", " -0700"},
+	"rfc3339": {"2006-01-02T15:04", "Z07:00"},
+	"iso8601": {"2006-01-02T15:04", "Z07:00"},
+}
+
+var logTimeFormatPrecisions = map[string]string{
+	"s":  ":05",
+	"ms": ":05.999",
+	"us": ":05.999999",
+	"ns": ":05.999999999",
+}
+
+// isUnixTimeFormat reports whether layout is one of zerolog's numeric epoch
+// formats, which are not Go layouts.
+func isUnixTimeFormat(layout string) bool {
+	switch layout {
+	case zerolog.TimeFormatUnix, zerolog.TimeFormatUnixMs, zerolog.TimeFormatUnixMicro, zerolog.TimeFormatUnixNano:
+		return true
+	}
+	return false
+}
+
+func isStrftimeFormat(str string) bool {
+	return strings.ContainsRune(str, '%')
+}
+
+func formatUnixTime(t time.Time, layout string) string {
+	switch layout {
+	case zerolog.TimeFormatUnixMs:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case zerolog.TimeFormatUnixMicro:
+		return strconv.FormatInt(t.UnixMicro(), 10)
+	case zerolog.TimeFormatUnixNano:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	default:
+		return strconv.FormatInt(t.Unix(), 10)
+	}
 }
 
 func ExpandTimeFormat(str string) string {
-	key := strings.ToLower(strings.ReplaceAll(str, "µ", "u"))
-	if value, found := logTimeFormatMap[key]; found {
-		return value
+	if layout, found, _ := lookupTimeFormat(str); found {
+		return layout
 	}
 	return str
 }
 
+func isTimeFormatAlias(str string) bool {
+	_, found, _ := lookupTimeFormat(str)
+	return found
+}
+
+// lookupTimeFormat expands an alias.  A known base with an unknown
+// precision suffix is an error rather than a literal layout.
+func lookupTimeFormat(str string) (string, bool, error) {
+	key := timeFormatKey(str)
+	if layout, found := logTimeFormatMap[key]; found {
+		return layout, true, nil
+	}
+
+	base, prec, hasPrec := strings.Cut(key, ".")
+	parts, found := logTimeFormatBases[base]
+	if !found {
+		return "", false, nil
+	}
+	var frac string
+	if hasPrec {
+		frac, found = logTimeFormatPrecisions[prec]
+		if !found {
+			return "", false, fmt.Errorf("time format %q: unknown precision %q; expected one of [\"s\", \"ms\", \"us\", \"ns\"]", str, prec)
+		}
+	}
+	return parts[0] + frac + parts[1], true, nil
+}
+
+// timeFormatNames lists every alias, including each base alias with each
+// precision suffix.
+func timeFormatNames() []string {
+	names := make([]string, 0, len(logTimeFormatMap)+len(logTimeFormatBases)*(1+len(logTimeFormatPrecisions)))
+	for name := range logTimeFormatMap {
+		names = append(names, name)
+	}
+	for base := range logTimeFormatBases {
+		names = append(names, base)
+		for prec := range logTimeFormatPrecisions {
+			names = append(names, base+"."+prec)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func timeFormatKey(str string) string {
+	return strings.ToLower(strings.ReplaceAll(str, "µ", "u"))
+}
+
+// layoutCheckTime differs from Go's reference time in every field, so a
+// layout that formats it back to the layout itself has no directives.
+var layoutCheckTime = time.Date(2023, 10, 10, 8, 40, 39, 111111111, time.FixedZone("PDT", -7*60*60))
+
+// ValidateTimeFormat expands an alias like ExpandTimeFormat, then checks
+// that the result is a Go time layout that round-trips a known time.  A
+// format containing '%' is a Strftime pattern instead, which only console
+// output supports.
+func ValidateTimeFormat(str string) (string, error) {
+	if isStrftimeFormat(str) {
+		if _, err := CompilePattern(str, Options{}); err != nil {
+			return "", fmt.Errorf("time format %q: %w", str, err)
+		}
+		return str, nil
+	}
+
+	if _, _, err := lookupTimeFormat(str); err != nil {
+		return "", err
+	}
+	layout := ExpandTimeFormat(str)
+	if str != "" && isUnixTimeFormat(layout) {
+		return layout, nil
+	}
+	formatted := layoutCheckTime.Format(layout)
+	if formatted == layout {
+		return "", fmt.Errorf("time format %q contains no time fields", str)
+	}
+	parsed, err := time.Parse(layout, formatted)
+	if err != nil {
+		return "", fmt.Errorf("time format %q does not round-trip: %w", str, err)
+	}
+	if again := parsed.Format(layout); again != formatted {
+		return "", fmt.Errorf("time format %q does not round-trip: %q != %q", str, again, formatted)
+	}
+	return layout, nil
+}
+
+// FormatAll renders t in every named LOG_TIMEFORMAT alias, keyed by name.
+func FormatAll(t time.Time) map[string]string {
+	names := timeFormatNames()
+	out := make(map[string]string, len(names))
+	for _, name := range names {
+		layout := ExpandTimeFormat(name)
+		if isUnixTimeFormat(layout) {
+			out[name] = formatUnixTime(t, layout)
+			continue
+		}
+		out[name] = t.Format(layout)
+	}
+	return out
+}
+
+// ExpandPath expands the Strftime conversions in a file name pattern.  Use
+// "%%" for a literal percent sign.
 func ExpandPath(str string, now time.Time) string {
 	return Strftime(str, now)
 }
 
+type Config struct {
+	Level      string `json:"level,omitempty" env:"LOG_LEVEL"`
+	Color      string `json:"color,omitempty" env:"LOG_COLOR"`
+	Output     string `json:"output,omitempty" env:"LOG_OUTPUT"`
+	Format     string `json:"format,omitempty" env:"LOG_FORMAT"`
+	TimeFormat string `json:"timeFormat,omitempty" env:"LOG_TIMEFORMAT"`
+	Caller     string `json:"caller,omitempty" env:"LOG_CALLER"`
+	Meta       string `json:"meta,omitempty" env:"LOG_META"`
+
+	// Stack enables stack traces for .Stack() events.  Only errors that
+	// carry a stack, such as those from github.com/pkg/errors, produce one.
+	Stack string `json:"stack,omitempty" env:"LOG_STACK"`
+
+	// KeySanitize is a toggle, or a regular expression matching the
+	// characters to replace with "_" in event keys.  "yes" replaces
+	// everything except ASCII letters, digits, and underscores.
+	KeySanitize string `json:"keySanitize,omitempty" env:"LOG_KEY_SANITIZE"`
+
+	// Sample keeps roughly one in N events below the warn level.  Values of
+	// 0 or 1 disable sampling.
+	Sample string `json:"sample,omitempty" env:"LOG_SAMPLE"`
+
+	// Strict makes a failure to open Output an error.  Otherwise autolog
+	// warns and falls back to stderr.
+	Strict string `json:"strict,omitempty" env:"LOG_STRICT"`
+
+	// ConsoleSortFields renders console fields in strict key order, without
+	// hoisting "error" to the front.
+	ConsoleSortFields string `json:"consoleSortFields,omitempty" env:"LOG_CONSOLE_SORT_FIELDS"`
+
+	// Existing selects how file: and pattern: outputs treat a file that
+	// already exists: "append" (the default), "exclusive", or "suffix".
+	// "rename", for file: outputs only, moves the file aside to
+	// "<name>.<RFC 3339 time>" so that each run starts a fresh file.  A
+	// Reconfigure that keeps the file already open does not move it.
+	Existing string `json:"existing,omitempty" env:"LOG_EXISTING"`
+
+	// FileMode is the octal permission mode for newly created log files,
+	// before the umask.  The default is 0666.
+	FileMode string `json:"fileMode,omitempty" env:"LOG_FILEMODE"`
+
+	// Mkdir creates missing parent directories of file: and pattern:
+	// outputs; it defaults to on.  DirMode is their octal permission mode,
+	// before the umask, and defaults to 0777.
+	Mkdir   string `json:"mkdir,omitempty" env:"LOG_MKDIR"`
+	DirMode string `json:"dirMode,omitempty" env:"LOG_DIRMODE"`
+
+	// ErrorOutput, if set, names a second output, in the same syntax as
+	// Output, that also receives events at ErrorLevel (default "warn") and
+	// above.
+	ErrorOutput string `json:"errorOutput,omitempty" env:"LOG_ERROR_OUTPUT"`
+	ErrorLevel  string `json:"errorLevel,omitempty" env:"LOG_ERROR_LEVEL"`
+
+	// FieldTime, FieldLevel, and FieldMessage rename the "time", "level",
+	// and "message" keys, e.g. to "@timestamp" or "severity".
+	FieldTime    string `json:"fieldTime,omitempty" env:"LOG_FIELD_TIME"`
+	FieldLevel   string `json:"fieldLevel,omitempty" env:"LOG_FIELD_LEVEL"`
+	FieldMessage string `json:"fieldMessage,omitempty" env:"LOG_FIELD_MESSAGE"`
+
+	// FieldOrder reorders the top-level keys of each JSON event.  "yes"
+	// puts the time, level, and message first; a comma-separated list of
+	// keys puts those first instead.  The rest follow alphabetically.  It
+	// has no effect on console output.
+	FieldOrder string `json:"fieldOrder,omitempty" env:"LOG_FIELD_ORDER"`
+
+	// PID adds the process ID to every event as "pid".  See UptimeHook for
+	// the process uptime.
+	PID string `json:"pid,omitempty" env:"LOG_PID"`
+
+	// Dedup is a toggle, or a duration for the window, that collapses runs
+	// of events with the same message into one event plus a "(repeated N
+	// times)" summary.  See DedupWriter.
+	Dedup string `json:"dedup,omitempty" env:"LOG_DEDUP"`
+
+	// NDJSON guarantees that each event reaches the output as one complete,
+	// newline-terminated line, flushed before the next.  See LineWriter.
+	NDJSON string `json:"ndjson,omitempty" env:"LOG_NDJSON"`
+
+	// LevelNames changes the level labels: "upper" or "lower" for their
+	// case, and "level=label" pairs to rename them, e.g. "warn=WARNING".
+	// It cannot be combined with the gcp format, which has its own names.
+	LevelNames string `json:"levelNames,omitempty" env:"LOG_LEVEL_NAMES"`
+
+	// Seq numbers every event with a "seq" field, in the order written.
+	// See SequenceWriter.
+	Seq string `json:"seq,omitempty" env:"LOG_SEQ"`
+
+	// FSync opens file: and pattern: outputs with O_SYNC, so that each
+	// event reaches stable storage before the write returns.  Outputs are
+	// not buffered, so nothing is held back; expect far lower throughput.
+	FSync string `json:"fsync,omitempty" env:"LOG_FSYNC"`
+
+	// TimeResolution selects the Unix timestamp used by JSON output when
+	// TimeFormat is unset: "s", "ms" (the default), "us", or "ns".
+	TimeResolution string `json:"timeResolution,omitempty" env:"LOG_TIME_RESOLUTION"`
+
+	// UTC renders every timestamp in UTC instead of the local zone.
+	UTC string `json:"utc,omitempty" env:"LOG_UTC"`
+
+	// Writer, if non-nil, is used as the output instead of Output.
+	Writer io.Writer `json:"-"`
+}
+
+// ConfigError reports a Config setting that was rejected.  Variable names
+// the setting by its environment variable, e.g. LogLevelVarName, whether or
+// not it came from the environment.  Init panics with, and Reconfigure
+// returns, a *ConfigError for every setting they reject.
+type ConfigError struct {
+	Variable string
+	Value    string
+	Err      error
+}
+
+func (err *ConfigError) Error() string {
+	return err.Variable + ": " + err.Err.Error()
+}
+
+func (err *ConfigError) Unwrap() error {
+	return err.Err
+}
+
+// configFromEnv reads the individual LOG_* variables, then overlays any
+// fields present in the JSON object in LOG_CONFIG.
+func configFromEnv() (Config, error) {
+	return configFromLookup(os.LookupEnv)
+}
+
+// configFromLookup is configFromEnv with lookup in place of os.LookupEnv.
+// Each string field of Config is read from the variable in its "env" tag.
+func configFromLookup(lookup func(string) (string, bool)) (Config, error) {
+	var cfg Config
+	v := reflect.ValueOf(&cfg).Elem()
+	for i, n := 0, v.NumField(); i < n; i++ {
+		name, ok := v.Type().Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		if str, found := lookup(name); found {
+			v.Field(i).SetString(str)
+		}
+	}
+
+	if str, _ := lookup(LogConfigVarName); str != "" {
+		d := json.NewDecoder(strings.NewReader(str))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&cfg); err != nil {
+			return Config{}, &ConfigError{Variable: LogConfigVarName, Value: str, Err: fmt.Errorf("invalid JSON: %w", err)}
+		}
+	}
+
+	return cfg, nil
+}
+
 var (
 	gOnce      sync.Once
+	gMu        sync.Mutex
 	gWriter    io.Writer
 	gNeedClose bool
+	gErrWriter io.Closer
+	gOutput    switchWriter
+	gShape     loggerShape
+	gBuilt     bool
+	gStopCtx   func() bool
+	gEffective Config
 )
 
 func Init() {
 	gOnce.Do(func() {
-		zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
-		zerolog.DurationFieldUnit = time.Second
-		zerolog.DurationFieldInteger = false
-
-		if str, found := os.LookupEnv(LogLevelVarName); found {
-			level, err := zerolog.ParseLevel(str)
-			if err != nil {
-				panic(fmt.Errorf("%s: %w", LogLevelVarName, err))
-			}
-			zerolog.SetGlobalLevel(level)
+		cfg, err := configFromEnv()
+		if err == nil {
+			err = configure(cfg)
+		}
+		if err != nil {
+			panic(err)
 		}
+	})
+}
 
-		logColor := triStateAuto
-		if str, found := os.LookupEnv(LogColorVarName); found {
-			if err := logColor.Parse(str); err != nil {
-				panic(fmt.Errorf("%s: %w", LogColorVarName, err))
-			}
+// InitContext is Init, plus a call to Done once ctx is cancelled, so that
+// outputs are flushed and closed when the program's work is over.  Calling
+// Done first detaches ctx.  Watching ctx starts no goroutine until it is
+// cancelled.
+func InitContext(ctx context.Context) {
+	Init()
+	gMu.Lock()
+	defer gMu.Unlock()
+	stopContext()
+	gStopCtx = context.AfterFunc(ctx, func() { _ = Done() })
+}
+
+// stopContext detaches the context given to InitContext, if any.  gMu must
+// be held.
+func stopContext() {
+	if gStopCtx != nil {
+		gStopCtx()
+		gStopCtx = nil
+	}
+}
+
+// Reconfigure replaces the active logging configuration.  It is safe to call
+// concurrently with logging: log.Logger writes through an indirection that
+// is swapped only once in-flight writes have finished, after which the old
+// output is closed if autolog opened it.
+func Reconfigure(cfg Config) error {
+	gOnce.Do(func() {})
+	return configure(cfg)
+}
+
+func configure(cfg Config) error {
+	gMu.Lock()
+	defer gMu.Unlock()
+
+	hasLevel := false
+	var level zerolog.Level
+	if cfg.Level != "" {
+		var err error
+		level, err = parseLevel(cfg.Level)
+		if err != nil {
+			return &ConfigError{Variable: LogLevelVarName, Value: cfg.Level, Err: err}
 		}
+		hasLevel = true
+	}
 
-		logOutput := getenv(LogOutputVarName, "stderr")
-		switch {
-		case logOutput == "stdout":
-			gWriter = os.Stdout
+	var logColor TriState
+	if err := logColor.Parse(cfg.Color); err != nil {
+		return &ConfigError{Variable: LogColorVarName, Value: cfg.Color, Err: err}
+	}
+
+	var logCaller TriState
+	if err := logCaller.Parse(cfg.Caller); err != nil {
+		return &ConfigError{Variable: LogCallerVarName, Value: cfg.Caller, Err: err}
+	}
+
+	var logMeta TriState
+	if err := logMeta.Parse(cfg.Meta); err != nil {
+		return &ConfigError{Variable: LogMetaVarName, Value: cfg.Meta, Err: err}
+	}
+
+	var logStack TriState
+	if err := logStack.Parse(cfg.Stack); err != nil {
+		return &ConfigError{Variable: LogStackVarName, Value: cfg.Stack, Err: err}
+	}
+
+	var logUTC TriState
+	if err := logUTC.Parse(cfg.UTC); err != nil {
+		return &ConfigError{Variable: LogUTCVarName, Value: cfg.UTC, Err: err}
+	}
+
+	var logPID TriState
+	if err := logPID.Parse(cfg.PID); err != nil {
+		return &ConfigError{Variable: LogPIDVarName, Value: cfg.PID, Err: err}
+	}
 
-		case logOutput == "stderr":
-			gWriter = os.Stderr
+	var logSeq TriState
+	if err := logSeq.Parse(cfg.Seq); err != nil {
+		return &ConfigError{Variable: LogSeqVarName, Value: cfg.Seq, Err: err}
+	}
+
+	var logFSync TriState
+	if err := logFSync.Parse(cfg.FSync); err != nil {
+		return &ConfigError{Variable: LogFSyncVarName, Value: cfg.FSync, Err: err}
+	}
+
+	var logNDJSON TriState
+	if err := logNDJSON.Parse(cfg.NDJSON); err != nil {
+		return &ConfigError{Variable: LogNDJSONVarName, Value: cfg.NDJSON, Err: err}
+	}
+
+	var logStrict TriState
+	if err := logStrict.Parse(cfg.Strict); err != nil {
+		return &ConfigError{Variable: LogStrictVarName, Value: cfg.Strict, Err: err}
+	}
+
+	var logConsoleSortFields TriState
+	if err := logConsoleSortFields.Parse(cfg.ConsoleSortFields); err != nil {
+		return &ConfigError{Variable: LogConsoleSortFieldsVarName, Value: cfg.ConsoleSortFields, Err: err}
+	}
+
+	var logTimeFormat string
+	if cfg.TimeFormat != "" {
+		var err error
+		logTimeFormat, err = ValidateTimeFormat(cfg.TimeFormat)
+		if err != nil {
+			return &ConfigError{Variable: LogTimeFormatVarName, Value: cfg.TimeFormat, Err: err}
+		}
+	}
 
-		case strings.HasPrefix(logOutput, "file:"):
-			var err error
-			gWriter, err = openFile(filepath.Clean(logOutput[5:]))
-			if err != nil {
-				panic(fmt.Errorf("%s: %w", LogOutputVarName, err))
+	errorLevel := zerolog.WarnLevel
+	if cfg.ErrorLevel != "" {
+		var err error
+		errorLevel, err = parseLevel(cfg.ErrorLevel)
+		if err != nil {
+			return &ConfigError{Variable: LogErrorLevelVarName, Value: cfg.ErrorLevel, Err: err}
+		}
+	}
+
+	var logFieldOrder []string
+	var fieldOrderMode TriState
+	if err := fieldOrderMode.Parse(cfg.FieldOrder); err != nil {
+		fieldOrderMode = TriStateYes
+		for _, key := range strings.Split(cfg.FieldOrder, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				return &ConfigError{Variable: LogFieldOrderVarName, Value: cfg.FieldOrder, Err: fmt.Errorf("empty key in %q", cfg.FieldOrder)}
 			}
-			gNeedClose = true
+			logFieldOrder = append(logFieldOrder, key)
+		}
+	}
+
+	logLevelNames, err := parseLevelNames(cfg.LevelNames)
+	if err != nil {
+		return &ConfigError{Variable: LogLevelNamesVarName, Value: cfg.LevelNames, Err: err}
+	}
+	if cfg.LevelNames != "" && cfg.Format == FormatGCP {
+		return &ConfigError{Variable: LogLevelNamesVarName, Value: cfg.LevelNames, Err: fmt.Errorf("not supported with the %q format", FormatGCP)}
+	}
+
+	logTimeResolution, found := logTimeResolutionMap[timeFormatKey(cfg.TimeResolution)]
+	if !found {
+		return &ConfigError{Variable: LogTimeResolutionVarName, Value: cfg.TimeResolution, Err: fmt.Errorf("unknown resolution %q; expected one of [\"s\", \"ms\", \"us\", \"ns\"]", cfg.TimeResolution)}
+	}
+
+	var logExisting ExistingMode
+	if err := logExisting.Parse(cfg.Existing); err != nil {
+		return &ConfigError{Variable: LogExistingVarName, Value: cfg.Existing, Err: err}
+	}
+
+	logFileMode, err := parseFileMode(cfg.FileMode, defaultFileMode)
+	if err != nil {
+		return &ConfigError{Variable: LogFileModeVarName, Value: cfg.FileMode, Err: err}
+	}
+
+	var logMkdir TriState
+	if err := logMkdir.Parse(cfg.Mkdir); err != nil {
+		return &ConfigError{Variable: LogMkdirVarName, Value: cfg.Mkdir, Err: err}
+	}
+
+	logDirMode, err := parseFileMode(cfg.DirMode, defaultDirMode)
+	if err != nil {
+		return &ConfigError{Variable: LogDirModeVarName, Value: cfg.DirMode, Err: err}
+	}
+
+	var logSample uint64
+	if cfg.Sample != "" {
+		var err error
+		logSample, err = strconv.ParseUint(cfg.Sample, 10, 32)
+		if err != nil {
+			return &ConfigError{Variable: LogSampleVarName, Value: cfg.Sample, Err: err}
+		}
+	}
+
+	dedupWindow, err := parseDedup(cfg.Dedup)
+	if err != nil {
+		return &ConfigError{Variable: LogDedupVarName, Value: cfg.Dedup, Err: err}
+	}
+
+	keySanitizeRE, err := parseKeySanitize(cfg.KeySanitize)
+	if err != nil {
+		return &ConfigError{Variable: LogKeySanitizeVarName, Value: cfg.KeySanitize, Err: err}
+	}
 
-		case strings.HasPrefix(logOutput, "pattern:"):
-			var err error
-			gWriter, err = NewRotatingLogWriter(filepath.Clean(logOutput[8:]), true)
-			if err != nil {
-				panic(fmt.Errorf("%s: %w", LogOutputVarName, err))
+	if logExisting == ExistingRename {
+		for _, output := range []string{cfg.Output, cfg.ErrorOutput} {
+			if spec, err := parseOutputSpec(output); err == nil && spec.scheme == "pattern" {
+				return &ConfigError{Variable: LogExistingVarName, Value: cfg.Existing, Err: fmt.Errorf("%q is only supported for file: outputs", logExisting)}
 			}
-			gNeedClose = true
+		}
+	}
 
-		default:
-			panic(fmt.Errorf("%s: expected \"stdout\", \"stderr\", or \"file:<path>\"", LogOutputVarName))
-		}
-
-		defaultLogFormat := "json"
-		if file, ok := gWriter.(*os.File); ok {
-			switch {
-			case isatty.IsTerminal(file.Fd()):
-				defaultLogFormat = "console"
-			case isatty.IsCygwinTerminal(file.Fd()):
-				defaultLogFormat = "console"
-			default:
-				if logColor == triStateAuto {
-					logColor = triStateNo
+	// renameErrs collects rename failures, which are only warnings, to be
+	// logged once the new configuration is in place.
+	var renameErrs []error
+	fo := fileOptions{
+		existing: logExisting,
+		mode:     logFileMode,
+		mkdir:    logMkdir.Bool(true),
+		dirMode:  logDirMode,
+		sync:     logFSync.Bool(false),
+		warn:     func(err error) { renameErrs = append(renameErrs, err) },
+	}
+	for _, w := range []any{gWriter, gErrWriter} {
+		if file, ok := w.(*os.File); ok {
+			fo.open = append(fo.open, file)
+		}
+	}
+	if logMeta == TriStateYes && cfg.Format != FormatConsole {
+		fo.header = MetaHeader
+	}
+
+	shape := loggerShape{
+		caller: logCaller.Bool(false),
+		stack:  logStack.Bool(false),
+		pid:    logPID.Bool(false),
+		utc:    logUTC.Bool(false),
+		sample: uint32(logSample),
+		levels: logLevelNames,
+	}
+
+	// A malformed output is an error even without LOG_STRICT, which only
+	// excuses outputs that fail to open.
+	var outSpec outputSpec
+	if cfg.Writer == nil {
+		if outSpec, _, err = resolveOutput(pick(cfg.Output, "stderr"), fo); err != nil {
+			return &ConfigError{Variable: LogOutputVarName, Value: cfg.Output, Err: err}
+		}
+	}
+	if cfg.ErrorOutput != "" {
+		errSpec, _, err := resolveOutput(cfg.ErrorOutput, fo)
+		if err != nil {
+			return &ConfigError{Variable: LogErrorOutputVarName, Value: cfg.ErrorOutput, Err: err}
+		}
+		// Opening the same file twice would write warn+ events to it twice.
+		if sameFileOutput(outSpec, errSpec) {
+			return &ConfigError{Variable: LogErrorOutputVarName, Value: cfg.ErrorOutput, Err: fmt.Errorf("names the same file as %s", LogOutputVarName)}
+		}
+	}
+
+	writer, needClose, openErr := openOutput(cfg, fo)
+	if openErr != nil {
+		openErr = &ConfigError{Variable: LogOutputVarName, Value: cfg.Output, Err: openErr}
+		if logStrict == TriStateYes {
+			return openErr
+		}
+		writer, needClose = os.Stderr, false
+	}
+
+	var errWriter io.Writer
+	var errNeedClose bool
+	var errOpenErr error
+	if cfg.ErrorOutput != "" {
+		errWriter, errNeedClose, errOpenErr = openOutput(Config{Output: cfg.ErrorOutput}, fo)
+		if errOpenErr != nil {
+			errOpenErr = &ConfigError{Variable: LogErrorOutputVarName, Value: cfg.ErrorOutput, Err: errOpenErr}
+			if logStrict == TriStateYes {
+				if needClose {
+					_ = writer.(io.Closer).Close()
 				}
+				return errOpenErr
 			}
 		}
+	}
+
+	isTerminal := false
+	if file, ok := writer.(*os.File); ok {
+		isTerminal = isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd())
+	}
+
+	// The formatters write through out and errOut; writer and errWriter
+	// remain the raw outputs, to be synced and closed.
+	out, errOut := writer, errWriter
+	if logNDJSON == TriStateYes {
+		out = NewLineWriter(writer)
+		if errWriter != nil {
+			errOut = NewLineWriter(errWriter)
+		}
+	}
+
+	// LOG_COLOR=yes or no overrides terminal detection in either direction.
+	defaultLogFormat := FormatJSON
+	if isTerminal {
+		defaultLogFormat = FormatConsole
+	}
+	if logColor == TriStateAuto {
+		logColor = TriStateNo
+		if isTerminal {
+			logColor = TriStateYes
+		}
+	}
+
+	logFormat := cfg.Format
+	if logFormat == "" {
+		logFormat = defaultLogFormat
+	}
+
+	// abort closes the outputs opened above before returning err.
+	abort := func(err error) error {
+		if needClose {
+			_ = writer.(io.Closer).Close()
+		}
+		if errNeedClose {
+			_ = errWriter.(io.Closer).Close()
+		}
+		return err
+	}
+
+	timeFieldFormat := logTimeResolution
+	levelFieldName := "level"
+	if logFormat == FormatGCP {
+		// Google Cloud Logging reads "severity" and RFC 3339 timestamps.
+		timeFieldFormat = time.RFC3339Nano
+		levelFieldName = "severity"
+		shape.severity = true
+	}
+
+	if fieldOrderMode == TriStateYes && logFieldOrder == nil {
+		logFieldOrder = []string{
+			pick(cfg.FieldTime, "time"),
+			pick(cfg.FieldLevel, levelFieldName),
+			pick(cfg.FieldMessage, "message"),
+		}
+	}
+
+	// jsonWriter builds the writer stack for the JSON formats.
+	pretty := logFormat == FormatJSONPretty
+	jsonWriter := func(w io.Writer) io.Writer {
+		if pretty {
+			w = prettyWriter{next: w}
+		}
+		if logFieldOrder != nil {
+			w = fieldOrderWriter{next: w, first: logFieldOrder}
+		}
+		return transformWriter{next: w}
+	}
+
+	var logWriter io.Writer
+	var c *zerolog.ConsoleWriter
+	switch logFormat {
+	case FormatJSON, FormatJSONPretty, FormatGCP:
+		logWriter = jsonWriter(out)
+	case FormatConsole:
+		c = &zerolog.ConsoleWriter{
+			Out:        out,
+			NoColor:    logColor == TriStateNo,
+			TimeFormat: ExpandTimeFormat(defaultConsoleTimeFormat),
+		}
+		logWriter = c
+		if logConsoleSortFields == TriStateYes {
+			logWriter = sortedConsoleWriter{cw: c}
+		}
+	default:
+		return abort(&ConfigError{Variable: LogFormatVarName, Value: cfg.Format, Err: fmt.Errorf("unknown log format %q; expected one of [\"console\", \"gcp\", \"json\", \"json-pretty\"]", logFormat)})
+	}
 
-		logFormat := getenv(LogFormatVarName, defaultLogFormat)
-		var logWriter io.Writer
-		var c *zerolog.ConsoleWriter
-		switch logFormat {
-		case "json":
-			logWriter = gWriter
-		case "console":
-			c = &zerolog.ConsoleWriter{Out: gWriter, NoColor: logColor == triStateNo}
-			logWriter = c
+	// consoleTime, if set, replaces ConsoleWriter's own timestamp
+	// rendering, which always converts to the local zone.
+	var consoleTime func(time.Time) string
+	if cfg.TimeFormat != "" {
+		// Epoch formats change how events are encoded, even for console
+		// output, which decodes them again for display.
+		switch {
+		case isStrftimeFormat(logTimeFormat) && c == nil:
+			return abort(&ConfigError{Variable: LogTimeFormatVarName, Value: cfg.TimeFormat, Err: errors.New("strftime time formats are only supported for console output")})
+		case isStrftimeFormat(logTimeFormat):
+			cp, _ := CompilePattern(logTimeFormat, Options{})
+			consoleTime = cp.Format
+		case c == nil || isUnixTimeFormat(logTimeFormat):
+			timeFieldFormat = logTimeFormat
 		default:
-			panic(fmt.Errorf("%s: unknown log format %q; expected one of [\"console\", \"json\"]", LogFormatVarName, logFormat))
+			c.TimeFormat = logTimeFormat
 		}
+	}
+	consoleLoc := time.Local
+	if logUTC == TriStateYes {
+		consoleLoc = time.UTC
+		if c != nil && consoleTime == nil {
+			layout := c.TimeFormat
+			consoleTime = func(t time.Time) string { return t.Format(layout) }
+		}
+	}
+	if c != nil && consoleTime != nil {
+		c.FormatTimestamp = consoleTimestamp(consoleTime, consoleLoc, c.NoColor)
+	}
 
-		logTimeFormat, found := os.LookupEnv(LogTimeFormatVarName)
-		if found {
-			logTimeFormat = ExpandTimeFormat(logTimeFormat)
-			if c == nil {
-				zerolog.TimeFieldFormat = logTimeFormat
-			} else {
-				c.TimeFormat = logTimeFormat
+	var errLogWriter io.Writer
+	if errWriter != nil {
+		// The error output uses the same format as the main output, but
+		// without color, since it is rarely a terminal.
+		errLogWriter = jsonWriter(errOut)
+		if c != nil {
+			ec := *c
+			ec.Out = errOut
+			ec.NoColor = true
+			if consoleTime != nil {
+				ec.FormatTimestamp = consoleTimestamp(consoleTime, consoleLoc, true)
 			}
+			errLogWriter = &ec
+			if logConsoleSortFields == TriStateYes {
+				errLogWriter = sortedConsoleWriter{cw: &ec}
+			}
+		}
+	}
+
+	if keySanitizeRE != nil {
+		logWriter = NewKeySanitizer(logWriter, keySanitizeRE)
+		if errLogWriter != nil {
+			errLogWriter = NewKeySanitizer(errLogWriter, keySanitizeRE)
 		}
+	}
+
+	if errLogWriter != nil {
+		logWriter = levelSplitWriter{main: logWriter, extra: errLogWriter, min: errorLevel}
+	}
+
+	// Numbering comes after the level split, so that events sent to both
+	// outputs carry the same number, and after deduplication, so that
+	// suppressed events do not leave gaps.
+	if logSeq.Bool(false) {
+		logWriter = NewSequenceWriter(logWriter)
+	}
+
+	if dedupWindow > 0 {
+		logWriter = NewDedupWriter(logWriter, dedupWindow)
+	}
+
+	setGlobal(&zerolog.TimeFieldFormat, timeFieldFormat)
+	setGlobal(&zerolog.DurationFieldUnit, time.Second)
+	setGlobal(&zerolog.DurationFieldInteger, false)
+	setGlobal(&zerolog.TimestampFieldName, pick(cfg.FieldTime, "time"))
+	setGlobal(&zerolog.LevelFieldName, pick(cfg.FieldLevel, levelFieldName))
+	setGlobal(&zerolog.MessageFieldName, pick(cfg.FieldMessage, "message"))
+	if hasLevel {
+		zerolog.SetGlobalLevel(level)
+	}
 
-		log.Logger = zerolog.New(logWriter).With().Timestamp().Logger()
+	oldWriter, oldNeedClose, oldErrWriter := gWriter, gNeedClose, gErrWriter
+	gWriter, gNeedClose, gErrWriter = writer, needClose, nil
+	if errNeedClose {
+		gErrWriter = errWriter.(io.Closer)
+	}
+	oldLogWriter := gOutput.Swap(logWriter)
+	if !gBuilt || shape != gShape {
+		if shape.caller {
+			zerolog.CallerMarshalFunc = shortCaller
+		}
+		zerolog.LevelFieldMarshalFunc = zerologLevel
+		if shape.levels != (levelNames{}) {
+			zerolog.LevelFieldMarshalFunc = shape.levels.marshal
+		}
+		if shape.severity {
+			zerolog.LevelFieldMarshalFunc = gcpSeverity
+		}
+		zerolog.ErrorStackMarshaler = nil
+		if shape.stack {
+			zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+		}
+		zerolog.TimestampFunc = time.Now
+		if shape.utc {
+			zerolog.TimestampFunc = nowUTC
+		}
+		log.Logger = shape.build(&gOutput)
 		zerolog.DefaultContextLogger = &log.Logger
-	})
+		gShape, gBuilt = shape, true
+	}
+
+	if openErr != nil {
+		log.Warn().Err(openErr).Msg("failed to open log output; falling back to stderr")
+	}
+	if errOpenErr != nil {
+		log.Warn().Err(errOpenErr).Msg("failed to open error log output; continuing without it")
+	}
+	for _, err := range renameErrs {
+		warnRename(err)
+	}
+
+	eff := cfg
+	eff.Level = ""
+	eff.Format = logFormat
+	eff.Color = resolved(logColor, false)
+	if cfg.Writer == nil {
+		eff.Output = pick(cfg.Output, "stderr")
+		if openErr != nil {
+			eff.Output = "stderr"
+		}
+	}
+	if cfg.ErrorOutput != "" && errOpenErr == nil {
+		eff.ErrorLevel = errorLevel.String()
+	} else {
+		eff.ErrorOutput, eff.ErrorLevel = "", ""
+	}
+	eff.Caller = resolved(logCaller, false)
+	eff.Meta = resolved(logMeta, false)
+	eff.Stack = resolved(logStack, false)
+	eff.Strict = resolved(logStrict, false)
+	eff.ConsoleSortFields = resolved(logConsoleSortFields, false)
+	eff.Existing = logExisting.String()
+	eff.Mkdir = resolved(logMkdir, true)
+	eff.PID = resolved(logPID, false)
+	eff.NDJSON = resolved(logNDJSON, false)
+	eff.Seq = resolved(logSeq, false)
+	eff.FSync = resolved(logFSync, false)
+	eff.UTC = resolved(logUTC, false)
+	eff.Dedup = TriStateNo.String()
+	if dedupWindow > 0 {
+		eff.Dedup = dedupWindow.String()
+	}
+	gEffective = eff
+
+	return shutdownOutputs(oldLogWriter, oldWriter, oldNeedClose, oldErrWriter)
+}
+
+// EffectiveConfig reports the configuration in effect, with defaults and
+// auto-detected values resolved: Format is always set, toggles such as Color
+// and Caller read "yes" or "no", and Output is "stderr" if the configured
+// output could not be opened.  Level is the current global level.
+func EffectiveConfig() Config {
+	gMu.Lock()
+	eff := gEffective
+	gMu.Unlock()
+	eff.Level = GetLevel().String()
+	return eff
+}
+
+// resolved renders enum as "yes" or "no", with auto meaning def.
+func resolved(enum TriState, def bool) string {
+	if enum.Bool(def) {
+		return TriStateYes.String()
+	}
+	return TriStateNo.String()
+}
+
+// parseDedup returns the LOG_DEDUP window, or 0 if deduplication is off.
+func parseDedup(str string) (time.Duration, error) {
+	var toggle TriState
+	if err := toggle.Parse(str); err == nil {
+		if toggle == TriStateYes {
+			return defaultDedupWindow, nil
+		}
+		return 0, nil
+	}
+	window, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("window %v must be positive", window)
+	}
+	return window, nil
+}
+
+func parseKeySanitize(str string) (*regexp.Regexp, error) {
+	var toggle TriState
+	if err := toggle.Parse(str); err == nil {
+		if toggle == TriStateYes {
+			return defaultKeySanitizeRE, nil
+		}
+		return nil, nil
+	}
+	return regexp.Compile(str)
+}
+
+// fileOptions holds the settings shared by file: and pattern: outputs.
+type fileOptions struct {
+	header   HeaderFunc
+	existing ExistingMode
+	mode     fs.FileMode
+	mkdir    bool
+	dirMode  fs.FileMode
+	sync     bool
+	warn     func(error)
+
+	// open lists the files of the outputs being replaced.  ExistingRename
+	// leaves them in place, since reopening one is not a restart.
+	open []*os.File
+}
+
+// openOutput opens cfg.Writer or cfg.Output.  Its errors do not name the
+// variable, which the caller knows.
+func openOutput(cfg Config, fo fileOptions) (io.Writer, bool, error) {
+	if cfg.Writer != nil {
+		return cfg.Writer, false, nil
+	}
+
+	logOutput := cfg.Output
+	if logOutput == "" {
+		logOutput = "stderr"
+	}
+
+	spec, fo, err := resolveOutput(logOutput, fo)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var w io.Writer
+	needClose := true
+	switch spec.scheme {
+	case "stdout":
+		w, needClose = os.Stdout, false
+
+	case "stderr":
+		w, needClose = os.Stderr, false
+
+	case "discard":
+		w, needClose = io.Discard, false
+
+	case "fd":
+		fd, _ := strconv.Atoi(spec.target)
+		w, err = openFD(fd)
+
+	case "file":
+		w, err = openFileOutput(filepath.Clean(spec.target), fo)
+
+	case "pattern":
+		opts := []RotatingOption{WithHeader(fo.header), WithExisting(fo.existing), WithFileMode(fo.mode)}
+		if fo.mkdir {
+			opts = append(opts, WithMkdir(fo.dirMode))
+		}
+		if fo.sync {
+			opts = append(opts, WithSync())
+		}
+		w, err = NewRotatingLogWriter(filepath.Clean(spec.target), true, opts...)
+
+	default:
+		w, err = NewNetWriter(spec.scheme, spec.target)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return w, needClose, nil
+}
+
+// openFileOutput opens a file: output.
+func openFileOutput(name string, fo fileOptions) (*os.File, error) {
+	if fo.mkdir {
+		if err := makeParentDir(name, fo.dirMode); err != nil {
+			return nil, err
+		}
+	}
+	if fo.existing == ExistingRename && !isOpenFile(name, fo.open) {
+		if _, err := renameAside(name, time.Now()); err != nil && fo.warn != nil {
+			fo.warn(err)
+		}
+	}
+	file, name, err := openExisting(name, fo.existing, fo.mode, fo.sync)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHeader(file, name, time.Now(), fo.header); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// MetaHeader is a HeaderFunc that writes a single JSON object describing the
+// log stream.  It carries a "_meta": true key so that parsers can tell it
+// apart from regular events.
+func MetaHeader(name string, opened time.Time) []byte {
+	host, _ := os.Hostname()
+	meta := struct {
+		Meta   bool   `json:"_meta"`
+		App    string `json:"app"`
+		Host   string `json:"host"`
+		Opened string `json:"opened"`
+	}{
+		Meta:   true,
+		App:    filepath.Base(os.Args[0]),
+		Host:   host,
+		Opened: opened.Format(time.RFC3339Nano),
+	}
+
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		panic(fmt.Errorf("json.Marshal: %w", err))
+	}
+	return append(raw, '\n')
 }
 
 func Writer() io.Writer {
+	gMu.Lock()
+	defer gMu.Unlock()
 	return gWriter
 }
 
 func Rotate() error {
-	if x, ok := gWriter.(*RotatingLogWriter); ok {
+	if x, ok := Writer().(*RotatingLogWriter); ok {
 		return x.Rotate()
 	}
 	return nil
 }
 
-func Done() error {
-	if gNeedClose {
-		return gWriter.(io.Closer).Close()
+// Sync flushes the current output to stable storage if it is a file or a
+// RotatingLogWriter, and does nothing otherwise.
+func Sync() error {
+	return syncWriter(Writer())
+}
+
+func syncWriter(w any) error {
+	switch x := w.(type) {
+	case *RotatingLogWriter:
+		return x.Sync()
+	case *os.File:
+		return syncFile(x.Name(), x)
+	case interface{ Sync() error }:
+		return x.Sync()
 	}
 	return nil
 }
 
+func Done() error {
+	gMu.Lock()
+	defer gMu.Unlock()
+	stopContext()
+	err := shutdownOutputs(gOutput.Load(), gWriter, gNeedClose, gErrWriter)
+	gErrWriter, gNeedClose = nil, false
+	return err
+}
+
+// ResetGlobals shuts down the active configuration, as Done does, and then
+// returns autolog and zerolog to their initial state, so that a later Init
+// reads the environment again.  It is meant for tests; it must not be called
+// while other goroutines are logging.
+func ResetGlobals() error {
+	gMu.Lock()
+	defer gMu.Unlock()
+	stopContext()
+	err := shutdownOutputs(gOutput.Swap(nil), gWriter, gNeedClose, gErrWriter)
+	gOnce = sync.Once{}
+	gWriter, gNeedClose, gErrWriter = nil, false, nil
+	gShape, gBuilt = loggerShape{}, false
+	gEffective = Config{}
+	gZerologDefaults.restore()
+	return err
+}
+
+// zerologGlobals is a snapshot of the zerolog globals that configure changes.
+type zerologGlobals struct {
+	level                 zerolog.Level
+	timeFieldFormat       string
+	durationFieldUnit     time.Duration
+	durationFieldInteger  bool
+	timestampFieldName    string
+	levelFieldName        string
+	messageFieldName      string
+	callerMarshalFunc     func(pc uintptr, file string, line int) string
+	levelFieldMarshalFunc func(l zerolog.Level) string
+	errorStackMarshaler   func(err error) interface{}
+	timestampFunc         func() time.Time
+	logger                zerolog.Logger
+	defaultContextLogger  *zerolog.Logger
+}
+
+// gZerologDefaults is taken before configure has had a chance to run.
+var gZerologDefaults = zerologGlobals{
+	level:                 zerolog.GlobalLevel(),
+	timeFieldFormat:       zerolog.TimeFieldFormat,
+	durationFieldUnit:     zerolog.DurationFieldUnit,
+	durationFieldInteger:  zerolog.DurationFieldInteger,
+	timestampFieldName:    zerolog.TimestampFieldName,
+	levelFieldName:        zerolog.LevelFieldName,
+	messageFieldName:      zerolog.MessageFieldName,
+	callerMarshalFunc:     zerolog.CallerMarshalFunc,
+	levelFieldMarshalFunc: zerolog.LevelFieldMarshalFunc,
+	errorStackMarshaler:   zerolog.ErrorStackMarshaler,
+	timestampFunc:         zerolog.TimestampFunc,
+	logger:                log.Logger,
+	defaultContextLogger:  zerolog.DefaultContextLogger,
+}
+
+func (z zerologGlobals) restore() {
+	zerolog.SetGlobalLevel(z.level)
+	zerolog.TimeFieldFormat = z.timeFieldFormat
+	zerolog.DurationFieldUnit = z.durationFieldUnit
+	zerolog.DurationFieldInteger = z.durationFieldInteger
+	zerolog.TimestampFieldName = z.timestampFieldName
+	zerolog.LevelFieldName = z.levelFieldName
+	zerolog.MessageFieldName = z.messageFieldName
+	zerolog.CallerMarshalFunc = z.callerMarshalFunc
+	zerolog.LevelFieldMarshalFunc = z.levelFieldMarshalFunc
+	zerolog.ErrorStackMarshaler = z.errorStackMarshaler
+	zerolog.TimestampFunc = z.timestampFunc
+	log.Logger = z.logger
+	zerolog.DefaultContextLogger = z.defaultContextLogger
+}
+
+// flusher is implemented by writers in the chain, such as DedupWriter, that
+// hold back output until flushed.
+type flusher interface {
+	Flush() error
+}
+
+// shutdownOutputs shuts down a configured writer stack in order: it flushes
+// whatever chain still holds back, then syncs and closes the primary output
+// (if owned) and the error output.  Every step runs even if an earlier one
+// fails, and all errors are returned.
+func shutdownOutputs(chain io.Writer, main io.Writer, mainOwned bool, extra io.Closer) error {
+	var errs []error
+	if f, ok := chain.(flusher); ok {
+		errs = append(errs, f.Flush())
+	}
+	if mainOwned {
+		errs = append(errs, syncWriter(main), main.(io.Closer).Close())
+	}
+	if extra != nil {
+		errs = append(errs, syncWriter(extra), extra.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// loggerShape holds the settings that are baked into log.Logger itself or
+// into zerolog's function-valued globals.  These are only touched when they
+// change, since replacing them is not safe while other goroutines are
+// logging.
+type loggerShape struct {
+	caller   bool
+	stack    bool
+	severity bool
+	pid      bool
+	utc      bool
+	sample   uint32
+	levels   levelNames
+}
+
+// gPID is read once; a process's ID does not change.
+var gPID = os.Getpid()
+
+func nowUTC() time.Time {
+	return time.Now().UTC()
+}
+
+func zerologLevel(l zerolog.Level) string {
+	return l.String()
+}
+
+// gcpSeverity maps zerolog levels onto Google Cloud Logging's LogSeverity
+// names.
+func gcpSeverity(l zerolog.Level) string {
+	switch l {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return "DEBUG"
+	case zerolog.InfoLevel:
+		return "INFO"
+	case zerolog.WarnLevel:
+		return "WARNING"
+	case zerolog.ErrorLevel:
+		return "ERROR"
+	case zerolog.FatalLevel:
+		return "CRITICAL"
+	case zerolog.PanicLevel:
+		return "ALERT"
+	default:
+		return "DEFAULT"
+	}
+}
+
+func (shape loggerShape) build(w io.Writer) zerolog.Logger {
+	c := zerolog.New(w).With().Timestamp()
+	if shape.caller {
+		c = c.Caller()
+	}
+	if shape.pid {
+		c = c.Int("pid", gPID)
+	}
+	logger := c.Logger().Hook(RequestIDHook{}).Hook(&gHooks)
+	if shape.sample > 1 {
+		logger = logger.Sample(zerolog.LevelSampler{
+			TraceSampler: &zerolog.BasicSampler{N: shape.sample},
+			DebugSampler: &zerolog.BasicSampler{N: shape.sample},
+			InfoSampler:  &zerolog.BasicSampler{N: shape.sample},
+		})
+	}
+	return logger
+}
+
+// shortCaller trims the file path to its last directory and file name.
+func shortCaller(pc uintptr, file string, line int) string {
+	dir, base := filepath.Split(file)
+	if dir != "" {
+		base = filepath.Join(filepath.Base(dir), base)
+	}
+	return base + ":" + strconv.Itoa(line)
+}
+
+type switchWriter struct {
+	mu sync.RWMutex
+	w  io.Writer
+}
+
+func (sw *switchWriter) Swap(w io.Writer) io.Writer {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	old := sw.w
+	sw.w = w
+	return old
+}
+
+func (sw *switchWriter) Load() io.Writer {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	return sw.w
+}
+
+func (sw *switchWriter) Write(p []byte) (int, error) {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	if sw.w == nil {
+		return len(p), nil
+	}
+	return sw.w.Write(p)
+}
+
+func (sw *switchWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	if sw.w == nil {
+		return len(p), nil
+	}
+	if lw, ok := sw.w.(zerolog.LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return sw.w.Write(p)
+}
+
+var _ zerolog.LevelWriter = (*switchWriter)(nil)
+
+// levelSplitWriter writes every event to main, and events at min or above
+// to extra as well.
+type levelSplitWriter struct {
+	main  io.Writer
+	extra io.Writer
+	min   zerolog.Level
+}
+
+func (w levelSplitWriter) Write(p []byte) (int, error) {
+	return w.main.Write(p)
+}
+
+func (w levelSplitWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	n, err := w.main.Write(p)
+	if level >= w.min && level != zerolog.NoLevel {
+		if _, err2 := w.extra.Write(p); err == nil {
+			err = err2
+		}
+	}
+	return n, err
+}
+
+var _ zerolog.LevelWriter = levelSplitWriter{}
+
 type RotatingLogWriter struct {
 	mu        sync.RWMutex
 	file      *os.File
 	name      string
+	expanded  string
 	pattern   string
 	isPattern bool
+	header    HeaderFunc
+	mkdir     bool
+	dirMode   fs.FileMode
+	existing  ExistingMode
+	fileMode  fs.FileMode
+	sync      bool
+	now       func() time.Time
+
+	pruneMu      sync.Mutex
+	keep         int
+	pruneEvery   time.Duration
+	lastPrune    time.Time
+	prunePending bool
+	pruneClosed  bool
+	pruneTimer   *time.Timer
+}
+
+// HeaderFunc returns the bytes to write at the start of each newly created
+// log file.  It is not called when reopening a file that already has data.
+type HeaderFunc func(name string, opened time.Time) []byte
+
+type RotatingOption func(w *RotatingLogWriter)
+
+func WithHeader(fn HeaderFunc) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.header = fn
+	}
+}
+
+// WithMkdir creates the parent directories of each file before opening it,
+// including on every Rotate, so patterns may expand to dated directories.
+func WithMkdir(mode fs.FileMode) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.mkdir = true
+		w.dirMode = mode
+	}
 }
 
-func NewRotatingLogWriter(pattern string, isPattern bool) (*RotatingLogWriter, error) {
-	name := pattern
-	if isPattern {
-		name = ExpandPath(name, time.Now())
+// WithExisting selects what happens when the file to open already exists.
+func WithExisting(mode ExistingMode) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.existing = mode
+	}
+}
+
+// WithFileMode sets the permission mode, before the umask, of newly created
+// files.  The default is 0666.
+func WithFileMode(mode fs.FileMode) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.fileMode = mode
+	}
+}
+
+// WithSync opens each file with O_SYNC, so that every write reaches stable
+// storage before it returns.
+func WithSync() RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.sync = true
+	}
+}
+
+// WithClock replaces time.Now as the source of the current time used to
+// expand the pattern, e.g. so tests can drive rotation with a fake clock.
+func WithClock(fn func() time.Time) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.now = fn
+	}
+}
+
+// NewRotatingLogWriter opens the file named by pattern.  If isPattern is
+// true, pattern is expanded by ExpandPath, so a literal "%" must be written
+// as "%%".
+func NewRotatingLogWriter(pattern string, isPattern bool, opts ...RotatingOption) (*RotatingLogWriter, error) {
+	w := &RotatingLogWriter{pattern: pattern, isPattern: isPattern, now: time.Now, fileMode: defaultFileMode, pruneEvery: defaultPruneInterval}
+	for _, opt := range opts {
+		opt(w)
 	}
 
-	file, err := openFile(name)
+	now := w.now()
+	expanded := w.expand(now)
+	file, name, err := w.open(expanded, now)
 	if err != nil {
 		return nil, err
 	}
 
-	w := &RotatingLogWriter{file: file, name: name, pattern: pattern, isPattern: isPattern}
+	w.name = name
+	w.expanded = expanded
+	w.file = file
 	return w, nil
 }
 
+// NextName returns the name that Rotate would open right now, without
+// opening it.  See NameAt.
+func (w *RotatingLogWriter) NextName() string {
+	notNil(w)
+	return w.NameAt(w.now())
+}
+
+// NameAt returns the name that Rotate would open at the given time.  It has
+// no side effects: %{counter:<name>} shows the counter's next value without
+// advancing it.  The prediction can still miss if the counter advances
+// first, or under ExistingSuffix, which adds "-<n>" if the name is taken.
+func (w *RotatingLogWriter) NameAt(now time.Time) string {
+	notNil(w)
+	if !w.isPattern {
+		return w.pattern
+	}
+	var buf bytes.Buffer
+	_ = formatPattern(&buf, w.pattern, timeConverter{t: now, opts: &Options{}, peek: true})
+	return buf.String()
+}
+
+func (w *RotatingLogWriter) expand(now time.Time) string {
+	if w.isPattern {
+		return ExpandPath(w.pattern, now)
+	}
+	return w.pattern
+}
+
+func (w *RotatingLogWriter) open(name string, now time.Time) (*os.File, string, error) {
+	if w.mkdir {
+		if err := makeParentDir(name, w.dirMode); err != nil {
+			return nil, "", err
+		}
+	}
+
+	file, name, err := openExisting(name, w.existing, w.fileMode, w.sync)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := writeHeader(file, name, now, w.header); err != nil {
+		_ = file.Close()
+		return nil, "", err
+	}
+
+	return file, name, nil
+}
+
+// Write appends p to the current file.  It holds the read lock for the
+// whole write, so Rotate, which swaps files under the write lock, cannot
+// return, sync, or close the old file while a Write to it is in flight.
 func (w *RotatingLogWriter) Write(p []byte) (int, error) {
 	notNil(w)
 
@@ -216,6 +1533,8 @@ func (w *RotatingLogWriter) Write(p []byte) (int, error) {
 func (w *RotatingLogWriter) Close() error {
 	notNil(w)
 
+	pruneErr := w.closePrune()
+
 	var name string
 	var file *os.File
 
@@ -224,18 +1543,35 @@ func (w *RotatingLogWriter) Close() error {
 	file, w.file = w.file, file
 	w.mu.Unlock()
 
-	return closeFile(name, file)
+	if err := closeFile(name, file); err != nil {
+		return err
+	}
+	return pruneErr
+}
+
+// Sync flushes the active file to stable storage.
+func (w *RotatingLogWriter) Sync() error {
+	notNil(w)
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.file == nil {
+		return fs.ErrClosed
+	}
+	return syncFile(w.name, w.file)
 }
 
+// Rotate reopens the output.  For patterns, it does nothing if the pattern
+// still expands to the name of the open file.
 func (w *RotatingLogWriter) Rotate() error {
 	notNil(w)
 
-	name := w.pattern
-	if w.isPattern {
-		name = ExpandPath(name, time.Now())
+	now := w.now()
+	expanded := w.expand(now)
+	if w.isPattern && expanded == w.currentExpanded() {
+		return w.prune(now, false)
 	}
 
-	file, err := openFile(name)
+	file, name, err := w.open(expanded, now)
 	if err != nil {
 		return err
 	}
@@ -243,9 +1579,19 @@ func (w *RotatingLogWriter) Rotate() error {
 	w.mu.Lock()
 	name, w.name = w.name, name
 	file, w.file = w.file, file
+	w.expanded = expanded
 	w.mu.Unlock()
 
-	return closeFile(name, file)
+	if err := closeFile(name, file); err != nil {
+		return err
+	}
+	return w.prune(now, true)
+}
+
+func (w *RotatingLogWriter) currentExpanded() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.expanded
 }
 
 func (w *RotatingLogWriter) WithFile(fn func(name string, file *os.File) error) error {
@@ -260,46 +1606,73 @@ var (
 	_ io.Closer = (*RotatingLogWriter)(nil)
 )
 
-type triState byte
+// TriState is a boolean setting that may also be left to a default, as the
+// LOG_COLOR, LOG_CALLER, and similar toggles are.  The zero value is
+// TriStateAuto.
+type TriState byte
 
 const (
-	triStateAuto triState = iota
-	triStateYes
-	triStateNo
+	TriStateAuto TriState = iota
+	TriStateYes
+	TriStateNo
 )
 
 var triStateNames = [...]string{"auto", "yes", "no"}
-var triStateMap = map[string]triState{
-	"":     triStateAuto,
-	"auto": triStateAuto,
-
-	"1":    triStateYes,
-	"y":    triStateYes,
-	"yes":  triStateYes,
-	"t":    triStateYes,
-	"true": triStateYes,
-	"on":   triStateYes,
-
-	"0":     triStateNo,
-	"n":     triStateNo,
-	"no":    triStateNo,
-	"f":     triStateNo,
-	"false": triStateNo,
-	"off":   triStateNo,
-}
-
-func (enum triState) String() string {
-	if enum < triState(len(triStateNames)) {
+var triStateMap = map[string]TriState{
+	"":     TriStateAuto,
+	"auto": TriStateAuto,
+
+	"1":    TriStateYes,
+	"y":    TriStateYes,
+	"yes":  TriStateYes,
+	"t":    TriStateYes,
+	"true": TriStateYes,
+	"on":   TriStateYes,
+
+	"0":     TriStateNo,
+	"n":     TriStateNo,
+	"no":    TriStateNo,
+	"f":     TriStateNo,
+	"false": TriStateNo,
+	"off":   TriStateNo,
+}
+
+func (enum TriState) String() string {
+	if enum < TriState(len(triStateNames)) {
 		return triStateNames[enum]
 	}
 	return triStateNames[0]
 }
 
-func (enum triState) MarshalText() ([]byte, error) {
+// Bool resolves enum to a boolean, with TriStateAuto yielding auto.
+func (enum TriState) Bool(auto bool) bool {
+	switch enum {
+	case TriStateYes:
+		return true
+	case TriStateNo:
+		return false
+	default:
+		return auto
+	}
+}
+
+func (enum TriState) MarshalText() ([]byte, error) {
 	return []byte(enum.String()), nil
 }
 
-func (enum *triState) Parse(input string) error {
+// UnmarshalText accepts the same spellings as Parse.
+func (enum *TriState) UnmarshalText(text []byte) error {
+	return enum.Parse(string(text))
+}
+
+var (
+	_ encoding.TextMarshaler   = TriState(0)
+	_ encoding.TextUnmarshaler = (*TriState)(nil)
+)
+
+// Parse accepts "auto" or "", and the usual spellings of yes and no, such as
+// "1", "true", "on", "0", "false", and "off", in any case.
+func (enum *TriState) Parse(input string) error {
 	*enum = 0
 
 	if value, found := triStateMap[input]; found {
@@ -316,21 +1689,220 @@ func (enum *triState) Parse(input string) error {
 	return fmt.Errorf("unknown tri-state value %q", input)
 }
 
-func getenv(name string, defaultValue string) string {
-	if value, found := os.LookupEnv(name); found {
-		return value
+// ExistingMode selects how a log file that already exists is handled.
+type ExistingMode byte
+
+const (
+	// ExistingAppend appends to the existing file.
+	ExistingAppend ExistingMode = iota
+
+	// ExistingExclusive fails rather than open an existing file.
+	ExistingExclusive
+
+	// ExistingSuffix opens the first unused name of the form
+	// "<base>-<n><ext>", e.g. "app-1.log", "app-2.log", and so on.
+	ExistingSuffix
+
+	// ExistingRename renames a file: output that already exists to
+	// "<name>.<RFC 3339 time>", then creates a fresh file.  If the rename
+	// fails, it appends instead.  RotatingLogWriter treats it as
+	// ExistingAppend.
+	ExistingRename
+)
+
+var existingModeNames = [...]string{"append", "exclusive", "suffix", "rename"}
+var existingModeMap = map[string]ExistingMode{
+	"":          ExistingAppend,
+	"append":    ExistingAppend,
+	"exclusive": ExistingExclusive,
+	"excl":      ExistingExclusive,
+	"suffix":    ExistingSuffix,
+	"rename":    ExistingRename,
+}
+
+func (mode ExistingMode) String() string {
+	if mode < ExistingMode(len(existingModeNames)) {
+		return existingModeNames[mode]
 	}
-	return defaultValue
+	return existingModeNames[0]
+}
+
+func (mode ExistingMode) MarshalText() ([]byte, error) {
+	return []byte(mode.String()), nil
 }
 
-func openFile(name string) (*os.File, error) {
-	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+func (mode *ExistingMode) Parse(input string) error {
+	*mode = 0
+
+	if value, found := existingModeMap[strings.ToLower(input)]; found {
+		*mode = value
+		return nil
+	}
+
+	return fmt.Errorf("unknown existing-file mode %q; expected one of [\"append\", \"exclusive\", \"rename\", \"suffix\"]", input)
+}
+
+// setGlobal only stores when the value changes, so that reconfiguring with
+// the same settings does not race with loggers reading zerolog's globals.
+func setGlobal[T comparable](ptr *T, value T) {
+	if *ptr != value {
+		*ptr = value
+	}
+}
+
+// maxSuffix bounds the search for an unused name in ExistingSuffix mode.
+const maxSuffix = 1000
+
+// warnRename logs a failure of ExistingRename, which then appends.
+func warnRename(err error) {
+	log.Warn().Err(err).Msg("failed to move old log file aside; appending to it")
+}
+
+// isOpenFile reports whether name is one of files.
+func isOpenFile(name string, files []*os.File) bool {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		if open, err := file.Stat(); err == nil && os.SameFile(fi, open) {
+			return true
+		}
+	}
+	return false
+}
+
+// renameAside moves the existing log file name out of the way, to
+// "<name>.<RFC 3339 time>", and returns the new name.  Empty files and
+// anything but a regular file, such as /dev/null or a symlink, are left in
+// place, and "" is returned.
+func renameAside(name string, now time.Time) (string, error) {
+	fi, err := os.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stat old log file: %q: %w", name, err)
+	}
+	if !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return "", nil
+	}
+
+	base := name + "." + now.UTC().Format(time.RFC3339)
+	candidate := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if i > maxSuffix {
+			return "", fmt.Errorf("failed to find an unused file name: %q: tried %d suffixes", base, maxSuffix)
+		}
+		candidate = base + "-" + strconv.Itoa(i)
+	}
+
+	if err := os.Rename(name, candidate); err != nil {
+		return "", fmt.Errorf("failed to rename old log file: %w", err)
+	}
+	return candidate, nil
+}
+
+// openExisting opens name for appending according to mode, returning the
+// name that was actually opened.  If sync is set, the file is opened with
+// O_SYNC.
+func openExisting(name string, mode ExistingMode, perm fs.FileMode, sync bool) (*os.File, string, error) {
+	var flag int
+	if sync {
+		flag = os.O_SYNC
+	}
+
+	switch mode {
+	case ExistingExclusive:
+		file, err := openFile(name, os.O_EXCL|flag, perm)
+		return file, name, err
+
+	case ExistingSuffix:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		candidate := name
+		for i := 1; i <= maxSuffix+1; i++ {
+			file, err := openFile(candidate, os.O_EXCL|flag, perm)
+			if err == nil {
+				return file, candidate, nil
+			}
+			if !errors.Is(err, fs.ErrExist) {
+				return nil, "", err
+			}
+			candidate = base + "-" + strconv.Itoa(i) + ext
+		}
+		return nil, "", fmt.Errorf("failed to find an unused file name: %q: tried %d suffixes", name, maxSuffix)
+
+	default:
+		file, err := openFile(name, flag, perm)
+		return file, name, err
+	}
+}
+
+const (
+	defaultFileMode fs.FileMode = 0o666
+	defaultDirMode  fs.FileMode = 0o777
+)
+
+func parseFileMode(str string, def fs.FileMode) (fs.FileMode, error) {
+	if str == "" {
+		return def, nil
+	}
+	u64, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || u64 > 0o777 {
+		return 0, fmt.Errorf("invalid octal permission mode %q", str)
+	}
+	return fs.FileMode(u64), nil
+}
+
+func makeParentDir(name string, mode fs.FileMode) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return fmt.Errorf("failed to create directory: %q: %w", dir, err)
+	}
+	return nil
+}
+
+func openFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND|flag, perm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file for appending: %q: %w", name, err)
 	}
 	return file, nil
 }
 
+func writeHeader(file *os.File, name string, now time.Time, fn HeaderFunc) error {
+	if fn == nil {
+		return nil
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %q: %w", name, err)
+	}
+	if fi.Size() != 0 {
+		return nil
+	}
+
+	if _, err := file.Write(fn(name, now)); err != nil {
+		return fmt.Errorf("failed to write header: %q: %w", name, err)
+	}
+	return nil
+}
+
+// syncFile fsyncs file.  Pipes and terminals, which cannot be synced, are
+// not an error.
+func syncFile(name string, file *os.File) error {
+	err := file.Sync()
+	if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return fmt.Errorf("failed to sync file: %q: %w", name, err)
+	}
+	return nil
+}
+
 func closeFile(name string, file *os.File) error {
 	if file == nil {
 		return fs.ErrClosed
@@ -0,0 +1,1506 @@
+package autolog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func resetLevel(t *testing.T) {
+	t.Helper()
+	level := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+}
+
+func TestReconfigure(t *testing.T) {
+	resetLevel(t)
+
+	var buf1, buf2 bytes.Buffer
+	if err := Reconfigure(Config{Level: "info", Format: "json", Writer: &buf1}); err != nil {
+		t.Fatalf("Reconfigure #1: %v", err)
+	}
+
+	log.Debug().Msg("one")
+	log.Info().Msg("two")
+
+	if err := Reconfigure(Config{Level: "debug", Format: "json", Writer: &buf2}); err != nil {
+		t.Fatalf("Reconfigure #2: %v", err)
+	}
+
+	log.Debug().Msg("three")
+
+	out1 := buf1.String()
+	out2 := buf2.String()
+	if strings.Contains(out1, `"one"`) {
+		t.Errorf("debug message logged at info level:\n%s", out1)
+	}
+	if !strings.Contains(out1, `"two"`) {
+		t.Errorf("info message missing from first writer:\n%s", out1)
+	}
+	if strings.Contains(out1, `"three"`) {
+		t.Errorf("message logged to old writer after Reconfigure:\n%s", out1)
+	}
+	if !strings.Contains(out2, `"three"`) {
+		t.Errorf("debug message missing from second writer:\n%s", out2)
+	}
+}
+
+func TestReconfigure_Invalid(t *testing.T) {
+	resetLevel(t)
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Level: "bogus", Writer: &buf}); err == nil {
+		t.Error("expected error for bad level, got nil")
+	}
+	if err := Reconfigure(Config{Format: "bogus", Writer: &buf}); err == nil {
+		t.Error("expected error for bad format, got nil")
+	}
+}
+
+func TestReconfigure_Concurrent(t *testing.T) {
+	resetLevel(t)
+
+	var mu sync.Mutex
+	var bufs [4]bytes.Buffer
+	writers := [4]*lockedWriter{}
+	for i := range writers {
+		writers[i] = &lockedWriter{mu: &mu, buf: &bufs[i]}
+	}
+
+	if err := Reconfigure(Config{Level: "info", Format: "json", Writer: writers[0]}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					log.Info().Msg("hello")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		cfg := Config{Level: "info", Format: "json", Writer: writers[i%len(writers)]}
+		if err := Reconfigure(cfg); err != nil {
+			t.Errorf("Reconfigure: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+type lockedWriter struct {
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestReconfigure_Caller(t *testing.T) {
+	resetLevel(t)
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Caller: "yes", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	_, _, line, _ := runtime.Caller(0)
+	log.Info().Msg("hello")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+
+	expect := fmt.Sprintf("%s/autolog_test.go:%d", filepath.Base(mustGetwd(t)), line+1)
+	if actual := m["caller"]; actual != expect {
+		t.Errorf("wrong caller:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+
+	buf.Reset()
+	if err := Reconfigure(Config{Format: "json", Caller: "no", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if strings.Contains(buf.String(), `"caller"`) {
+		t.Errorf("unexpected caller field:\n%s", buf.String())
+	}
+}
+
+func mustGetwd(t *testing.T) string {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("os.Getwd: %v", err)
+	}
+	return dir
+}
+
+func TestReconfigure_Meta(t *testing.T) {
+	resetLevel(t)
+
+	name := filepath.Join(t.TempDir(), "app.log")
+	if err := Reconfigure(Config{Output: "file:" + name, Meta: "yes"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if err := Reconfigure(Config{Format: "json", Writer: io.Discard}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	lines := readLines(t, name)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), lines)
+	}
+	assertMetaLine(t, lines[0])
+	if strings.Contains(lines[1], "_meta") {
+		t.Errorf("regular event carries _meta: %q", lines[1])
+	}
+}
+
+func TestReconfigure_Stack(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	for _, stack := range []string{"yes", "no"} {
+		var buf bytes.Buffer
+		if err := Reconfigure(Config{Format: "json", Stack: stack, Writer: &buf}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+
+		log.Error().Stack().Err(errors.New("boom")).Msg("failed")
+
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+		}
+		_, found := m["stack"]
+		if expect := stack == "yes"; found != expect {
+			t.Errorf("LOG_STACK=%s: expected stack field present=%v, got %v:\n%s", stack, expect, found, buf.String())
+		}
+	}
+}
+
+func TestReconfigure_KeySanitize(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", KeySanitize: "yes", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	log.Info().Int("http.status code", 404).Str("path", "/a.b c").Msg("done")
+
+	out := buf.String()
+	if !strings.Contains(out, `"http_status_code":404`) {
+		t.Errorf("key not sanitized:\n%s", out)
+	}
+	if !strings.Contains(out, `"path":"/a.b c"`) {
+		t.Errorf("value was modified:\n%s", out)
+	}
+
+	if err := Reconfigure(Config{KeySanitize: "[", Writer: &buf}); err == nil {
+		t.Error("expected error for bad regexp, got nil")
+	}
+}
+
+func TestReconfigure_Sample(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	countLines := func(buf *bytes.Buffer, needle string) int {
+		return strings.Count(buf.String(), needle)
+	}
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Level: "debug", Format: "json", Sample: "10", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		log.Info().Msg("info")
+		log.Debug().Msg("debug")
+		log.Warn().Msg("warn")
+	}
+
+	if n := countLines(&buf, `"message":"info"`); n < 90 || n > 110 {
+		t.Errorf("expected ~100 sampled info events, got %d", n)
+	}
+	if n := countLines(&buf, `"message":"debug"`); n < 90 || n > 110 {
+		t.Errorf("expected ~100 sampled debug events, got %d", n)
+	}
+	if n := countLines(&buf, `"message":"warn"`); n != 1000 {
+		t.Errorf("expected all 1000 warn events, got %d", n)
+	}
+
+	for _, sample := range []string{"0", "1"} {
+		buf.Reset()
+		if err := Reconfigure(Config{Level: "debug", Format: "json", Sample: sample, Writer: &buf}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			log.Info().Msg("info")
+		}
+		if n := countLines(&buf, `"message":"info"`); n != 100 {
+			t.Errorf("LOG_SAMPLE=%s: expected 100 events, got %d", sample, n)
+		}
+	}
+
+	if err := Reconfigure(Config{Sample: "often", Writer: &buf}); err == nil {
+		t.Error("expected error for bad sample rate, got nil")
+	}
+}
+
+func TestReconfigure_Strict(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0o666); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	bad := "file:" + filepath.Join(notDir, "app.log")
+
+	if err := Reconfigure(Config{Output: bad, Strict: "yes"}); err == nil {
+		t.Error("strict: expected error for unwritable path, got nil")
+	}
+
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatalf("os.Create: %v", err)
+	}
+	defer stderr.Close()
+	saved := os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() { os.Stderr = saved })
+
+	if err := Reconfigure(Config{Output: bad, Format: "json", Strict: "no"}); err != nil {
+		t.Fatalf("lenient: unexpected error: %v", err)
+	}
+	if w := Writer(); w != stderr {
+		t.Errorf("lenient: expected fallback to stderr, got %T", w)
+	}
+	log.Info().Msg("after fallback")
+
+	raw, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+	out := string(raw)
+	if !strings.Contains(out, `"level":"warn"`) || !strings.Contains(out, "falling back to stderr") {
+		t.Errorf("lenient: missing fallback warning:\n%s", out)
+	}
+	if !strings.Contains(out, "after fallback") {
+		t.Errorf("lenient: subsequent events not written to stderr:\n%s", out)
+	}
+
+	// A malformed output is rejected rather than replaced by stderr.
+	for _, cfg := range []Config{
+		{Output: "fiel:/tmp/x", Format: "json", Strict: "no"},
+		{Output: "stderr", ErrorOutput: "fiel:/tmp/x", Format: "json", Strict: "no"},
+	} {
+		var cfgErr *ConfigError
+		if err := Reconfigure(cfg); !errors.As(err, &cfgErr) || cfgErr.Value != "fiel:/tmp/x" {
+			t.Errorf("lenient: expected *ConfigError for %+v, got %v", cfg, err)
+		}
+	}
+	if w := Writer(); w != stderr {
+		t.Errorf("lenient: a rejected configuration replaced the output with %T", w)
+	}
+}
+
+func TestReconfigure_ConsoleTimeFormat(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	consoleTimeFormat := func() string {
+		t.Helper()
+		gOutput.mu.RLock()
+		defer gOutput.mu.RUnlock()
+		c, ok := gOutput.w.(*zerolog.ConsoleWriter)
+		if !ok {
+			t.Fatalf("expected *zerolog.ConsoleWriter, got %T", gOutput.w)
+		}
+		return c.TimeFormat
+	}
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "console", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if actual, expect := consoleTimeFormat(), "3:04:05.999PM"; actual != expect {
+		t.Errorf("default: expected %q, got %q", expect, actual)
+	}
+	if zerolog.TimeFieldFormat != zerolog.TimeFormatUnixMs {
+		t.Errorf("default: JSON time format changed to %q", zerolog.TimeFieldFormat)
+	}
+
+	if err := Reconfigure(Config{Format: "console", TimeFormat: "rfc3339", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if actual, expect := consoleTimeFormat(), "2006-01-02T15:04Z07:00"; actual != expect {
+		t.Errorf("explicit: expected %q, got %q", expect, actual)
+	}
+}
+
+func TestReconfigure_BadPattern(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	err := Reconfigure(Config{Output: "pattern:" + filepath.Join(dir, "app-%Y%Q.log"), Strict: "no"})
+	if err == nil {
+		t.Fatal("expected error for bad pattern, got nil")
+	}
+
+	var perr *PatternError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *PatternError, got %T: %v", err, err)
+	}
+	if perr.Verb != "Q" {
+		t.Errorf("expected bad verb %q, got %q", "Q", perr.Verb)
+	}
+	if !strings.HasPrefix(err.Error(), LogOutputVarName+": ") {
+		t.Errorf("error does not name %s: %v", LogOutputVarName, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("expected no files to be created, found %d", len(entries))
+	}
+}
+
+func TestFormatAll(t *testing.T) {
+	z0 := time.FixedZone("MST", -7*60*60)
+	t0 := time.Unix(1136239445, 123456789).In(z0) // 2006-01-02T15:04:05.123456789-0700
+
+	all := FormatAll(t0)
+	if len(all) != len(timeFormatNames()) {
+		t.Errorf("expected %d aliases, got %d", len(timeFormatNames()), len(all))
+	}
+
+	expect := map[string]string{
+		"kitchen":    "3:04PM",
+		"kitchen.ms": "3:04:05.123PM",
+		"rfc822.s":   "02 Jan 2006 15:04:05 -0700",
+		"rfc1123":    "Mon, 02 Jan 2006 15:04 -0700",
+		"rfc3339.ns": "2006-01-02T15:04:05.123456789-07:00",
+		"iso8601.us": "2006-01-02T15:04:05.123456-07:00",
+		"unix":       "1136239445",
+		"unixms":     "1136239445123",
+	}
+	for name, value := range expect {
+		if actual := all[name]; actual != value {
+			t.Errorf("%s: expected %q, got %q", name, value, actual)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	for _, output := range []string{"file:" + filepath.Join(dir, "a.log"), "pattern:" + filepath.Join(dir, "b-%Y.log")} {
+		if err := Reconfigure(Config{Format: "json", Output: output}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		log.Info().Msg("hello")
+		if err := Sync(); err != nil {
+			t.Errorf("%s: Sync: %v", output, err)
+		}
+
+		var name string
+		switch x := Writer().(type) {
+		case *os.File:
+			name = x.Name()
+		case *RotatingLogWriter:
+			name = x.NextName()
+		}
+		if lines := readLines(t, name); len(lines) != 1 || !strings.Contains(lines[0], "hello") {
+			t.Errorf("%s: expected the event on disk before close, got %q", output, lines)
+		}
+	}
+
+	if err := Reconfigure(Config{Format: "json", Writer: io.Discard}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if err := Sync(); err != nil {
+		t.Errorf("io.Discard: Sync: %v", err)
+	}
+}
+
+func TestReconfigure_FieldNames(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	cfg := Config{
+		Format:       "json",
+		FieldTime:    "@timestamp",
+		FieldLevel:   "severity",
+		FieldMessage: "msg",
+		Writer:       &buf,
+	}
+	if err := Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	for _, key := range []string{"@timestamp", "severity", "msg"} {
+		if _, found := m[key]; !found {
+			t.Errorf("missing %q: %s", key, buf.String())
+		}
+	}
+	for _, key := range []string{"time", "level", "message"} {
+		if _, found := m[key]; found {
+			t.Errorf("unexpected %q: %s", key, buf.String())
+		}
+	}
+
+	// Unset names go back to zerolog's defaults.
+	buf.Reset()
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if !strings.Contains(buf.String(), `"message":"hello"`) {
+		t.Errorf("expected default message key: %s", buf.String())
+	}
+}
+
+func TestConfigFromEnv_LogConfig(t *testing.T) {
+	t.Setenv(LogLevelVarName, "warn")
+	t.Setenv(LogFormatVarName, "console")
+	t.Setenv(LogCallerVarName, "yes")
+	t.Setenv(LogConfigVarName, `{"level":"debug","format":"json"}`)
+
+	cfg, err := configFromEnv()
+	if err != nil {
+		t.Fatalf("configFromEnv: %v", err)
+	}
+	if cfg.Level != "debug" {
+		t.Errorf("Level: expected LOG_CONFIG's %q, got %q", "debug", cfg.Level)
+	}
+	if cfg.Format != "json" {
+		t.Errorf("Format: expected LOG_CONFIG's %q, got %q", "json", cfg.Format)
+	}
+	if cfg.Caller != "yes" {
+		t.Errorf("Caller: expected fallback %q, got %q", "yes", cfg.Caller)
+	}
+
+	for _, bad := range []string{`{"level":`, `{"levle":"debug"}`, `[]`} {
+		t.Setenv(LogConfigVarName, bad)
+		if _, err := configFromEnv(); err == nil {
+			t.Errorf("%s: expected error, got nil", bad)
+		} else if !strings.Contains(err.Error(), LogConfigVarName) {
+			t.Errorf("%s: error does not name %s: %v", bad, LogConfigVarName, err)
+		}
+	}
+}
+
+func TestConfigFromLookup(t *testing.T) {
+	env := map[string]string{
+		LogLevelVarName:       "debug",
+		LogOutputVarName:      "file:/tmp/app.log",
+		LogTimeFormatVarName:  "rfc3339",
+		LogErrorOutputVarName: "stderr",
+		LogPIDVarName:         "yes",
+		"UNRELATED":           "ignored",
+	}
+	lookup := func(name string) (string, bool) {
+		str, found := env[name]
+		return str, found
+	}
+
+	cfg, err := configFromLookup(lookup)
+	if err != nil {
+		t.Fatalf("configFromLookup: %v", err)
+	}
+	expect := Config{
+		Level:       "debug",
+		Output:      "file:/tmp/app.log",
+		TimeFormat:  "rfc3339",
+		ErrorOutput: "stderr",
+		PID:         "yes",
+	}
+	if cfg != expect {
+		t.Errorf("expected %+v, got %+v", expect, cfg)
+	}
+
+	env[LogConfigVarName] = `{"output":"stdout"}`
+	cfg, err = configFromLookup(lookup)
+	if err != nil {
+		t.Fatalf("configFromLookup: %v", err)
+	}
+	if cfg.Output != "stdout" || cfg.Level != "debug" {
+		t.Errorf("LOG_CONFIG overlay: got %+v", cfg)
+	}
+
+	// Every variable reaches exactly one field.
+	names := []string{
+		LogLevelVarName, LogColorVarName, LogOutputVarName, LogFormatVarName,
+		LogTimeFormatVarName, LogCallerVarName, LogMetaVarName, LogStackVarName,
+		LogKeySanitizeVarName, LogSampleVarName, LogStrictVarName,
+		LogConsoleSortFieldsVarName, LogExistingVarName, LogFieldTimeVarName,
+		LogFieldLevelVarName, LogFieldMessageVarName, LogFileModeVarName,
+		LogMkdirVarName, LogDirModeVarName, LogErrorOutputVarName,
+		LogErrorLevelVarName, LogPIDVarName, LogDedupVarName,
+		LogTimeResolutionVarName, LogUTCVarName, LogNDJSONVarName, LogLevelNamesVarName, LogFieldOrderVarName, LogSeqVarName, LogFSyncVarName,
+	}
+	for _, name := range names {
+		cfg, err := configFromLookup(func(key string) (string, bool) {
+			if key != name {
+				return "", false
+			}
+			return "x", true
+		})
+		if err != nil {
+			t.Fatalf("%s: configFromLookup: %v", name, err)
+		}
+		data, _ := json.Marshal(cfg)
+		if n := strings.Count(string(data), `"x"`); n != 1 {
+			t.Errorf("%s: populated %d fields: %s", name, n, data)
+		}
+	}
+}
+
+func TestReconfigure_GCP(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "gcp", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Error().Msg("boom")
+
+	if !strings.Contains(buf.String(), `"severity":"ERROR"`) {
+		t.Errorf("expected severity ERROR: %s", buf.String())
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	ts, ok := m["time"].(string)
+	if !ok {
+		t.Fatalf("expected string time: %s", buf.String())
+	}
+	if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		t.Errorf("time is not RFC 3339: %q: %v", ts, err)
+	}
+
+	// Switching back restores zerolog's level names.
+	buf.Reset()
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Error().Msg("boom")
+	if !strings.Contains(buf.String(), `"level":"error"`) {
+		t.Errorf("expected level error: %s", buf.String())
+	}
+}
+
+func TestValidateTimeFormat(t *testing.T) {
+	type testRow struct {
+		Input  string
+		Expect string
+		OK     bool
+	}
+
+	testData := [...]testRow{
+		{"rfc3339.ms", "2006-01-02T15:04:05.999Z07:00", true},
+		{"Kitchen", "3:04PM", true},
+		{"2006/01/02 15:04:05", "2006/01/02 15:04:05", true},
+		{"hello world", "", false},
+		{"", "", false},
+		{"rfc1123", "Mon, 02 Jan 2006 15:04 -0700", true},
+		{"rfc1123.us", "Mon, 02 Jan 2006 15:04:05.999999 -0700", true},
+		{"ISO8601.µs", "2006-01-02T15:04:05.999999Z07:00", true},
+		{"rfc3339.cs", "", false},
+		{"kitchen.", "", false},
+	}
+
+	for _, row := range testData {
+		actual, err := ValidateTimeFormat(row.Input)
+		switch {
+		case row.OK && err != nil:
+			t.Errorf("%q: unexpected error: %v", row.Input, err)
+		case !row.OK && err == nil:
+			t.Errorf("%q: expected error, got %q", row.Input, actual)
+		case actual != row.Expect:
+			t.Errorf("%q: expected %q, got %q", row.Input, row.Expect, actual)
+		}
+	}
+
+	if _, err := ValidateTimeFormat("rfc3339.cs"); err == nil || !strings.Contains(err.Error(), `unknown precision "cs"`) {
+		t.Errorf("rfc3339.cs: expected unknown precision error, got %v", err)
+	}
+
+	err := Reconfigure(Config{Format: "json", TimeFormat: "yyyy-mm-dd", Writer: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), LogTimeFormatVarName) {
+		t.Errorf("Reconfigure: expected %s error, got %v", LogTimeFormatVarName, err)
+	}
+}
+
+func TestReconfigure_UnixTimeFormat(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	type testRow struct {
+		TimeFormat string
+		Unit       time.Duration
+	}
+
+	testData := [...]testRow{
+		{"unix", time.Second},
+		{"unixms", time.Millisecond},
+		{"UnixUs", time.Microsecond},
+		{"unixµs", time.Microsecond},
+		{"unixns", time.Nanosecond},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		if err := Reconfigure(Config{Format: "json", TimeFormat: row.TimeFormat, Writer: &buf}); err != nil {
+			t.Fatalf("%s: Reconfigure: %v", row.TimeFormat, err)
+		}
+		before := time.Now()
+		log.Info().Msg("hello")
+		after := time.Now()
+
+		d := json.NewDecoder(&buf)
+		d.UseNumber()
+		var m map[string]any
+		if err := d.Decode(&m); err != nil {
+			t.Fatalf("%s: json.Decode: %v", row.TimeFormat, err)
+		}
+		num, ok := m["time"].(json.Number)
+		if !ok {
+			t.Errorf("%s: expected numeric time, got %#v", row.TimeFormat, m["time"])
+			continue
+		}
+		value, err := num.Int64()
+		if err != nil {
+			t.Errorf("%s: expected integer time, got %q", row.TimeFormat, num)
+			continue
+		}
+		lo := before.UnixNano() / int64(row.Unit)
+		hi := after.UnixNano() / int64(row.Unit)
+		if value < lo || value > hi {
+			t.Errorf("%s: expected time in [%d, %d], got %d", row.TimeFormat, lo, hi, value)
+		}
+	}
+}
+
+func TestReconfigure_Color(t *testing.T) {
+	resetLevel(t)
+	t.Setenv("NO_COLOR", "")
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	type testRow struct {
+		Color  string
+		Expect bool
+	}
+
+	testData := [...]testRow{
+		{"", false},
+		{"yes", true},
+		{"no", false},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		if err := Reconfigure(Config{Format: "console", Color: row.Color, Writer: &buf}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		log.Info().Msg("hello")
+		if actual := strings.Contains(buf.String(), "\x1b["); actual != row.Expect {
+			t.Errorf("buffer: LOG_COLOR=%q: expected escapes=%v, got %q", row.Color, row.Expect, buf.String())
+		}
+
+		// A pipe is an *os.File that is not a terminal.
+		if err := Reconfigure(Config{Format: "console", Color: row.Color, Writer: w}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		log.Info().Msg("hello")
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString: %v", err)
+		}
+		if actual := strings.Contains(line, "\x1b["); actual != row.Expect {
+			t.Errorf("pipe: LOG_COLOR=%q: expected escapes=%v, got %q", row.Color, row.Expect, line)
+		}
+	}
+}
+
+func TestReconfigure_FileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permission bits are not supported on Windows")
+	}
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	outputs := map[string]string{
+		"file:" + filepath.Join(dir, "a.log"):       filepath.Join(dir, "a.log"),
+		"pattern:" + filepath.Join(dir, "b-%Y.log"): ExpandPath(filepath.Join(dir, "b-%Y.log"), time.Now()),
+	}
+	for output, name := range outputs {
+		if err := Reconfigure(Config{Format: "json", Output: output, FileMode: "640"}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("os.Stat: %v", err)
+		}
+		// The umask may clear bits, but must never add them.
+		if perm := fi.Mode().Perm(); perm&^0o640 != 0 || perm&0o600 != 0o600 {
+			t.Errorf("%s: expected mode 0640 less umask, got %#o", output, perm)
+		}
+	}
+
+	for _, bad := range []string{"999", "rw-r-----", "1777"} {
+		err := Reconfigure(Config{Format: "json", FileMode: bad, Writer: io.Discard})
+		if err == nil || !strings.Contains(err.Error(), LogFileModeVarName) {
+			t.Errorf("%q: expected %s error, got %v", bad, LogFileModeVarName, err)
+		}
+	}
+}
+
+func TestReconfigure_Mkdir(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	now := time.Now()
+	outputs := map[string]string{
+		"file:" + filepath.Join(dir, "a", "b", "app.log"):              filepath.Join(dir, "a", "b", "app.log"),
+		"pattern:" + filepath.Join(dir, "logs", "%Y", "%m", "app.log"): ExpandPath(filepath.Join(dir, "logs", "%Y", "%m", "app.log"), now),
+	}
+	for output, name := range outputs {
+		if err := Reconfigure(Config{Format: "json", Output: output, Strict: "yes"}); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		if fi, err := os.Stat(filepath.Dir(name)); err != nil || !fi.IsDir() {
+			t.Errorf("%s: expected directory %q: %v", output, filepath.Dir(name), err)
+		}
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s: expected file %q: %v", output, name, err)
+		}
+	}
+
+	output := "file:" + filepath.Join(dir, "c", "app.log")
+	if err := Reconfigure(Config{Format: "json", Output: output, Strict: "yes", Mkdir: "no"}); err == nil {
+		t.Errorf("LOG_MKDIR=no: expected error for missing directory")
+	}
+}
+
+func TestReconfigure_ErrorOutput(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	mainName := filepath.Join(dir, "app.log")
+	errName := filepath.Join(dir, "app.err")
+
+	cfg := Config{
+		Level:       "debug",
+		Format:      "json",
+		Output:      "file:" + mainName,
+		ErrorOutput: "file:" + errName,
+	}
+	if err := Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("info")
+	log.Warn().Msg("warn")
+	log.Error().Msg("error")
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+
+	messages := func(name string) []string {
+		var out []string
+		for _, line := range readLines(t, name) {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				t.Fatalf("%s: json.Unmarshal: %v", name, err)
+			}
+			out = append(out, fmt.Sprint(m["message"]))
+		}
+		return out
+	}
+	if actual, expect := messages(mainName), []string{"info", "warn", "error"}; strings.Join(actual, ",") != strings.Join(expect, ",") {
+		t.Errorf("main: expected %q, got %q", expect, actual)
+	}
+	if actual, expect := messages(errName), []string{"warn", "error"}; strings.Join(actual, ",") != strings.Join(expect, ",") {
+		t.Errorf("error output: expected %q, got %q", expect, actual)
+	}
+
+	var buf bytes.Buffer
+	cfg = Config{Format: "json", Writer: &buf, ErrorOutput: "file:" + errName, ErrorLevel: "error"}
+	if err := Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Warn().Msg("warn2")
+	log.Error().Msg("error2")
+	if err := Reconfigure(Config{Format: "json", Writer: io.Discard}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if actual, expect := messages(errName), []string{"warn", "error", "error2"}; strings.Join(actual, ",") != strings.Join(expect, ",") {
+		t.Errorf("LOG_ERROR_LEVEL=error: expected %q, got %q", expect, actual)
+	}
+
+	err := Reconfigure(Config{Format: "json", Writer: io.Discard, ErrorLevel: "loud"})
+	if err == nil || !strings.Contains(err.Error(), LogErrorLevelVarName) {
+		t.Errorf("expected %s error, got %v", LogErrorLevelVarName, err)
+	}
+
+	// The error output may not name the main output's file, however it is
+	// spelled.
+	for _, errOutput := range []string{
+		"file:" + mainName,
+		"file:" + filepath.Join(dir, ".", "sub", "..", "app.log"),
+		"file://" + filepath.ToSlash(mainName),
+		"pattern:" + mainName,
+	} {
+		err := Reconfigure(Config{Format: "json", Output: "file:" + mainName, ErrorOutput: errOutput})
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) || cfgErr.Variable != LogErrorOutputVarName || cfgErr.Value != errOutput {
+			t.Errorf("%s=%q: expected %s error, got %v", LogErrorOutputVarName, errOutput, LogErrorOutputVarName, err)
+		}
+	}
+}
+
+func TestReconfigure_Discard(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	for _, output := range []string{"discard", "null"} {
+		if err := Reconfigure(Config{Output: output, Strict: "yes"}); err != nil {
+			t.Fatalf("%s: Reconfigure: %v", output, err)
+		}
+		if w := Writer(); w != io.Discard {
+			t.Errorf("%s: expected io.Discard, got %T", output, w)
+		}
+		log.Error().Msg("dropped")
+		if err := Done(); err != nil {
+			t.Errorf("%s: Done: %v", output, err)
+		}
+	}
+}
+
+func TestReconfigure_ConsoleStrftimeTimeFormat(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	const pattern = "%Y-%m-%d %H:%M:%S"
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "console", TimeFormat: pattern, Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	before := time.Now().Truncate(time.Second)
+	log.Info().Msg("hello")
+	after := time.Now()
+
+	line := buf.String()
+	if len(line) < 19 {
+		t.Fatalf("short line: %q", line)
+	}
+	stamp, err := time.ParseInLocation("2006-01-02 15:04:05", line[:19], time.Local)
+	if err != nil {
+		t.Fatalf("timestamp %q does not match %q: %v", line[:19], pattern, err)
+	}
+	if stamp.Before(before) || stamp.After(after) {
+		t.Errorf("timestamp %v outside [%v, %v]", stamp, before, after)
+	}
+
+	err = Reconfigure(Config{Format: "json", TimeFormat: pattern, Writer: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), LogTimeFormatVarName) {
+		t.Errorf("json: expected %s error, got %v", LogTimeFormatVarName, err)
+	}
+	if _, err := ValidateTimeFormat("%Y-%Q"); err == nil {
+		t.Errorf("ValidateTimeFormat: expected error for invalid pattern")
+	}
+}
+
+func TestReconfigure_JSONPretty(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json-pretty", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Str("k", "v").Msg("hello")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) < 4 || lines[0] != "{" || lines[len(lines)-1] != "}" {
+		t.Fatalf("expected indented JSON, got %q", buf.String())
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if !strings.HasPrefix(line, "  \"") {
+			t.Errorf("line %q is not indented by two spaces", line)
+		}
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if fields["message"] != "hello" || fields["k"] != "v" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+}
+
+func TestPrettyWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := prettyWriter{next: &buf}
+	input := "{\"a\":1,\"b\":[2]}\nnot json\n{\"c\":{}}\n"
+	n, err := pw.Write([]byte(input))
+	if err != nil || n != len(input) {
+		t.Fatalf("Write: n=%d err=%v", n, err)
+	}
+	expect := "{\n  \"a\": 1,\n  \"b\": [\n    2\n  ]\n}\nnot json\n{\n  \"c\": {}\n}\n"
+	if actual := buf.String(); actual != expect {
+		t.Errorf("expected %q, got %q", expect, actual)
+	}
+}
+
+func TestReconfigure_TimeResolution(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	type testRow struct {
+		Resolution string
+		Unit       time.Duration
+	}
+
+	testData := [...]testRow{
+		{"", time.Millisecond},
+		{"s", time.Second},
+		{"ms", time.Millisecond},
+		{"us", time.Microsecond},
+		{"µs", time.Microsecond},
+		{"ns", time.Nanosecond},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		if err := Reconfigure(Config{Format: "json", TimeResolution: row.Resolution, Writer: &buf}); err != nil {
+			t.Fatalf("%q: Reconfigure: %v", row.Resolution, err)
+		}
+		before := time.Now()
+		log.Info().Msg("hello")
+		after := time.Now()
+
+		d := json.NewDecoder(&buf)
+		d.UseNumber()
+		var m map[string]any
+		if err := d.Decode(&m); err != nil {
+			t.Fatalf("%q: Decode: %v", row.Resolution, err)
+		}
+		n, err := m["time"].(json.Number).Int64()
+		if err != nil {
+			t.Fatalf("%q: time %v is not an integer: %v", row.Resolution, m["time"], err)
+		}
+		lo := before.Truncate(row.Unit).UnixNano() / int64(row.Unit)
+		hi := after.UnixNano() / int64(row.Unit)
+		if n < lo || n > hi {
+			t.Errorf("%q: time %d outside [%d, %d]", row.Resolution, n, lo, hi)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", TimeResolution: "ns", TimeFormat: "unix", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if sec, ok := m["time"].(float64); !ok || sec > float64(time.Now().Unix()+1) {
+		t.Errorf("LOG_TIMEFORMAT should override LOG_TIME_RESOLUTION: %s", buf.String())
+	}
+
+	err := Reconfigure(Config{Format: "json", TimeResolution: "fortnight", Writer: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), LogTimeResolutionVarName) {
+		t.Errorf("expected %s error, got %v", LogTimeResolutionVarName, err)
+	}
+}
+
+func TestReconfigure_UTC(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	oldLocal := time.Local
+	time.Local = time.FixedZone("IST", 5*60*60+30*60)
+	t.Cleanup(func() { time.Local = oldLocal })
+
+	type testRow struct {
+		Format     string
+		TimeFormat string
+		UTC        string
+		Expect     string
+	}
+
+	testData := [...]testRow{
+		{"json", "rfc3339.s", "no", `+05:30"`},
+		{"json", "rfc3339.s", "yes", `Z"`},
+		{"gcp", "", "yes", `Z"`},
+		{"console", "15:04 MST", "no", " IST "},
+		{"console", "15:04 MST", "yes", " UTC "},
+		{"console", "%H:%M %z", "yes", " +0000 "},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		cfg := Config{Format: row.Format, TimeFormat: row.TimeFormat, UTC: row.UTC, Color: "no", Writer: &buf}
+		if err := Reconfigure(cfg); err != nil {
+			t.Fatalf("%+v: Reconfigure: %v", row, err)
+		}
+		log.Info().Msg("hello")
+		if !strings.Contains(buf.String(), row.Expect) {
+			t.Errorf("%+v: expected %q in %q", row, row.Expect, buf.String())
+		}
+	}
+
+	// A layout without a zone still shows the UTC wall clock.
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "console", TimeFormat: "%H", UTC: "yes", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if hour := time.Now().UTC().Format("15"); !strings.HasPrefix(buf.String(), hour) {
+		t.Errorf("expected UTC hour %s: %q", hour, buf.String())
+	}
+}
+
+func TestDone_Ordered(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	output := "pattern:" + filepath.Join(dir, "app-%Y.log")
+	if err := Reconfigure(Config{Format: "json", Output: output, Dedup: "1h", Level: "info"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	name := Writer().(*RotatingLogWriter).NextName()
+	for i := 0; i < 3; i++ {
+		log.Info().Msg("again")
+	}
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+
+	// The summary held back by the dedup layer reached the file before it
+	// was closed.
+	lines := readLines(t, name)
+	if len(lines) != 2 || !strings.Contains(lines[1], "again (repeated 2 times)") {
+		t.Errorf("expected the event and its summary, got %q", lines)
+	}
+}
+
+type failingOutput struct {
+	bytes.Buffer
+	syncErr  error
+	closeErr error
+}
+
+func (f *failingOutput) Sync() error  { return f.syncErr }
+func (f *failingOutput) Close() error { return f.closeErr }
+
+func TestShutdownOutputs_JoinsErrors(t *testing.T) {
+	errMainSync := errors.New("main sync")
+	errMainClose := errors.New("main close")
+	errExtraClose := errors.New("extra close")
+	main := &failingOutput{syncErr: errMainSync, closeErr: errMainClose}
+	extra := &failingOutput{closeErr: errExtraClose}
+
+	err := shutdownOutputs(io.Discard, main, true, extra)
+	for _, want := range []error{errMainSync, errMainClose, errExtraClose} {
+		if !errors.Is(err, want) {
+			t.Errorf("expected %v in %v", want, err)
+		}
+	}
+	if err := shutdownOutputs(io.Discard, main, false, nil); err != nil {
+		t.Errorf("unowned output: expected nil, got %v", err)
+	}
+}
+
+func TestResetGlobals_InitFromEnv(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = ResetGlobals() })
+
+	type testCase struct {
+		name   string
+		env    map[string]string
+		expect map[string]string
+	}
+
+	testData := []testCase{
+		{"json", map[string]string{LogFormatVarName: "json"}, map[string]string{"level": "info", "message": "hello"}},
+		{"gcp", map[string]string{LogFormatVarName: "gcp"}, map[string]string{"severity": "INFO", "message": "hello"}},
+		{"fields", map[string]string{LogFormatVarName: "json", LogFieldLevelVarName: "lvl", LogFieldMessageVarName: "msg"}, map[string]string{"lvl": "info", "msg": "hello"}},
+		{"json again", map[string]string{LogFormatVarName: "json"}, map[string]string{"level": "info", "message": "hello"}},
+	}
+
+	dir := t.TempDir()
+	for i, row := range testData {
+		name := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		for _, key := range []string{LogFormatVarName, LogFieldLevelVarName, LogFieldMessageVarName} {
+			t.Setenv(key, row.env[key])
+		}
+		t.Setenv(LogOutputVarName, "file:"+name)
+
+		if err := ResetGlobals(); err != nil {
+			t.Fatalf("%s: ResetGlobals: %v", row.name, err)
+		}
+		Init()
+		log.Info().Msg("hello")
+		if err := Done(); err != nil {
+			t.Fatalf("%s: Done: %v", row.name, err)
+		}
+
+		lines := readLines(t, name)
+		if len(lines) != 1 {
+			t.Errorf("%s: expected one event, got %q", row.name, lines)
+			continue
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+			t.Errorf("%s: %v", row.name, err)
+			continue
+		}
+		for key, value := range row.expect {
+			if event[key] != value {
+				t.Errorf("%s: expected %s=%q, got %q", row.name, key, value, lines[0])
+			}
+		}
+	}
+
+	if err := ResetGlobals(); err != nil {
+		t.Fatalf("ResetGlobals: %v", err)
+	}
+	if zerolog.LevelFieldName != "level" || zerolog.MessageFieldName != "message" {
+		t.Errorf("zerolog field names not restored: %q, %q", zerolog.LevelFieldName, zerolog.MessageFieldName)
+	}
+	if w := gOutput.Load(); w != nil {
+		t.Errorf("expected no output after reset, got %T", w)
+	}
+}
+
+func TestInitContext(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = ResetGlobals() })
+
+	dir := t.TempDir()
+	t.Setenv(LogFormatVarName, "json")
+
+	// closed reports whether the output file has been closed.
+	closed := func(file *os.File) bool {
+		_, err := file.Write(nil)
+		return errors.Is(err, os.ErrClosed)
+	}
+	waitClosed := func(file *os.File) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for !closed(file) {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return true
+	}
+
+	// Cancelling the context shuts down the outputs, and leaves no
+	// goroutine behind.
+	t.Setenv(LogOutputVarName, "file:"+filepath.Join(dir, "1.log"))
+	if err := ResetGlobals(); err != nil {
+		t.Fatalf("ResetGlobals: %v", err)
+	}
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	InitContext(ctx)
+	file := Writer().(*os.File)
+	if closed(file) {
+		t.Fatal("output closed before cancel")
+	}
+	cancel()
+	if !waitClosed(file) {
+		t.Fatal("output still open after cancel")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+
+	// Done detaches the context, so a later cancel does not close the
+	// next configuration's output.
+	t.Setenv(LogOutputVarName, "file:"+filepath.Join(dir, "2.log"))
+	if err := ResetGlobals(); err != nil {
+		t.Fatalf("ResetGlobals: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	InitContext(ctx)
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+	if err := Reconfigure(Config{Format: "json", Output: "file:" + filepath.Join(dir, "3.log")}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	file = Writer().(*os.File)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if closed(file) {
+		t.Error("cancel after Done closed the new output")
+	}
+}
+
+func TestTriState_JSON(t *testing.T) {
+	type wrapper struct {
+		Color TriState `json:"color"`
+	}
+
+	for _, value := range []TriState{TriStateAuto, TriStateYes, TriStateNo} {
+		raw, err := json.Marshal(wrapper{Color: value})
+		if err != nil {
+			t.Fatalf("%v: json.Marshal: %v", value, err)
+		}
+		if expect := `{"color":"` + value.String() + `"}`; string(raw) != expect {
+			t.Errorf("%v: expected %s, got %s", value, expect, raw)
+		}
+		var w wrapper
+		if err := json.Unmarshal(raw, &w); err != nil {
+			t.Errorf("%v: json.Unmarshal: %v", value, err)
+		} else if w.Color != value {
+			t.Errorf("%v: round trip gave %v", value, w.Color)
+		}
+	}
+
+	var w wrapper
+	if err := json.Unmarshal([]byte(`{"color":"ON"}`), &w); err != nil || w.Color != TriStateYes {
+		t.Errorf(`"ON": expected yes, got (%v, %v)`, w.Color, err)
+	}
+	if err := json.Unmarshal([]byte(`{"color":"sometimes"}`), &w); err == nil {
+		t.Errorf(`"sometimes": expected error`)
+	}
+}
+
+func TestTriState(t *testing.T) {
+	type testCase struct {
+		Input  string
+		Expect TriState
+		String string
+	}
+
+	testData := [...]testCase{
+		{"", TriStateAuto, "auto"},
+		{"Auto", TriStateAuto, "auto"},
+		{"yes", TriStateYes, "yes"},
+		{"TRUE", TriStateYes, "yes"},
+		{"on", TriStateYes, "yes"},
+		{"1", TriStateYes, "yes"},
+		{"no", TriStateNo, "no"},
+		{"Off", TriStateNo, "no"},
+		{"0", TriStateNo, "no"},
+	}
+
+	for _, row := range testData {
+		var enum TriState
+		if err := enum.Parse(row.Input); err != nil {
+			t.Errorf("%q: unexpected error: %v", row.Input, err)
+			continue
+		}
+		if enum != row.Expect {
+			t.Errorf("%q: expected %v, got %v", row.Input, row.Expect, enum)
+		}
+		if actual := enum.String(); actual != row.String {
+			t.Errorf("%q: String: expected %q, got %q", row.Input, row.String, actual)
+		}
+		if text, _ := enum.MarshalText(); string(text) != row.String {
+			t.Errorf("%q: MarshalText: expected %q, got %q", row.Input, row.String, text)
+		}
+	}
+
+	var enum TriState
+	if err := enum.Parse("maybe"); err == nil {
+		t.Errorf("%q: expected error", "maybe")
+	}
+
+	for _, auto := range []bool{false, true} {
+		if actual := TriStateAuto.Bool(auto); actual != auto {
+			t.Errorf("TriStateAuto.Bool(%v): got %v", auto, actual)
+		}
+		if !TriStateYes.Bool(auto) {
+			t.Errorf("TriStateYes.Bool(%v): got false", auto)
+		}
+		if TriStateNo.Bool(auto) {
+			t.Errorf("TriStateNo.Bool(%v): got true", auto)
+		}
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = ResetGlobals() })
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+
+	type testCase struct {
+		Name  string
+		Env   map[string]string
+		Check func(t *testing.T, eff Config)
+	}
+
+	testData := []testCase{
+		{"defaults", map[string]string{LogOutputVarName: "file:" + name}, func(t *testing.T, eff Config) {
+			// A file is not a terminal, so auto resolves to JSON, no color.
+			expect := Config{Format: FormatJSON, Color: "no", Output: "file:" + name, Level: GetLevel().String()}
+			if eff.Format != expect.Format || eff.Color != expect.Color || eff.Output != expect.Output || eff.Level != expect.Level {
+				t.Errorf("expected %+v, got %+v", expect, eff)
+			}
+			if eff.Caller != "no" || eff.Mkdir != "yes" || eff.Existing != "append" || eff.Dedup != "no" {
+				t.Errorf("toggles not resolved: %+v", eff)
+			}
+		}},
+		{"explicit", map[string]string{
+			LogOutputVarName: "file:" + name,
+			LogFormatVarName: "console",
+			LogColorVarName:  "on",
+			LogLevelVarName:  "warn",
+			LogCallerVarName: "1",
+			LogDedupVarName:  "5s",
+		}, func(t *testing.T, eff Config) {
+			if eff.Format != FormatConsole || eff.Color != "yes" || eff.Level != "warn" || eff.Caller != "yes" || eff.Dedup != "5s" {
+				t.Errorf("wrong resolution: %+v", eff)
+			}
+		}},
+		{"fallback", map[string]string{LogOutputVarName: "file:" + filepath.Join(name, "not-a-dir", "x.log"), LogMkdirVarName: "no"}, func(t *testing.T, eff Config) {
+			if eff.Output != "stderr" {
+				t.Errorf("expected the stderr fallback, got %q", eff.Output)
+			}
+		}},
+	}
+
+	keys := []string{LogOutputVarName, LogFormatVarName, LogColorVarName, LogLevelVarName, LogCallerVarName, LogDedupVarName, LogMkdirVarName}
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			for _, key := range keys {
+				t.Setenv(key, row.Env[key])
+			}
+			if err := ResetGlobals(); err != nil {
+				t.Fatalf("ResetGlobals: %v", err)
+			}
+			Init()
+			row.Check(t, EffectiveConfig())
+		})
+	}
+
+	SetLevel(zerolog.ErrorLevel)
+	if eff := EffectiveConfig(); eff.Level != "error" {
+		t.Errorf("SetLevel: expected level %q, got %q", "error", eff.Level)
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Output: "discard"}) })
+
+	type testCase struct {
+		Config   Config
+		Variable string
+		Value    string
+	}
+
+	testData := [...]testCase{
+		{Config{Format: "json", Output: "discard", Level: "loud"}, LogLevelVarName, "loud"},
+		{Config{Format: "json", Output: "bogus:x", Strict: "yes"}, LogOutputVarName, "bogus:x"},
+		{Config{Format: "json", Output: "fd:abc", Strict: "yes"}, LogOutputVarName, "fd:abc"},
+		{Config{Format: "xml", Output: "discard"}, LogFormatVarName, "xml"},
+		{Config{Format: "json", Output: "discard", Sample: "-1"}, LogSampleVarName, "-1"},
+	}
+
+	for _, row := range testData {
+		err := Reconfigure(row.Config)
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("%+v: expected *ConfigError, got %v", row.Config, err)
+			continue
+		}
+		if cfgErr.Variable != row.Variable || cfgErr.Value != row.Value {
+			t.Errorf("%+v: expected %s=%q, got %s=%q", row.Config, row.Variable, row.Value, cfgErr.Variable, cfgErr.Value)
+		}
+		if !strings.HasPrefix(err.Error(), row.Variable+": ") || errors.Unwrap(err) == nil {
+			t.Errorf("%+v: wrong message or cause: %v", row.Config, err)
+		}
+	}
+
+	// An error output's failures name its own variable, and only it.
+	err := Reconfigure(Config{Format: "json", Output: "discard", ErrorOutput: "bogus:x", Strict: "yes"})
+	expect := LogErrorOutputVarName + ": " + errBadOutput.Error()
+	if err == nil || err.Error() != expect {
+		t.Errorf("wrong message:\n\texpect: %s\n\tactual: %v", expect, err)
+	}
+
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0o666); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	bad := "file:" + filepath.Join(notDir, "x.log")
+	err = Reconfigure(Config{Format: "json", Output: "discard", ErrorOutput: bad, Strict: "yes"})
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Variable != LogErrorOutputVarName || cfgErr.Value != bad {
+		t.Errorf("expected %s error, got %v", LogErrorOutputVarName, err)
+	} else if errors.As(cfgErr.Err, &cfgErr) || strings.Contains(err.Error(), LogOutputVarName+":") {
+		t.Errorf("error output failure also names %s: %v", LogOutputVarName, err)
+	}
+}
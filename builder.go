@@ -0,0 +1,179 @@
+package autolog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Log formats accepted by Config.Format and Builder.Format.
+const (
+	FormatJSON       = "json"
+	FormatJSONPretty = "json-pretty"
+	FormatGCP        = "gcp"
+	FormatConsole    = "console"
+)
+
+// Builder assembles a logger fluently, for code that would rather inject a
+// *zerolog.Logger than use the global one.  Errors are reported by Build.
+type Builder struct {
+	level      zerolog.Level
+	hasLevel   bool
+	out        io.Writer
+	format     string
+	color      TriState
+	caller     bool
+	timeFormat string
+	global     bool
+}
+
+// New returns a Builder with the same defaults as Init: JSON to stderr, or
+// console if stderr is a terminal.
+func New() *Builder {
+	return &Builder{}
+}
+
+func (b *Builder) Level(level zerolog.Level) *Builder {
+	b.level, b.hasLevel = level, true
+	return b
+}
+
+func (b *Builder) Output(w io.Writer) *Builder {
+	b.out = w
+	return b
+}
+
+func (b *Builder) Format(format string) *Builder {
+	b.format = format
+	return b
+}
+
+func (b *Builder) Color(enabled bool) *Builder {
+	b.color = TriStateNo
+	if enabled {
+		b.color = TriStateYes
+	}
+	return b
+}
+
+func (b *Builder) Caller(enabled bool) *Builder {
+	b.caller = enabled
+	return b
+}
+
+func (b *Builder) TimeFormat(format string) *Builder {
+	b.timeFormat = format
+	return b
+}
+
+// Global makes Build install the logger as log.Logger, exactly as
+// Reconfigure would, instead of returning an independent one.
+func (b *Builder) Global() *Builder {
+	b.global = true
+	return b
+}
+
+// Config returns the equivalent Config.
+func (b *Builder) Config() Config {
+	cfg := Config{
+		Format:     b.format,
+		TimeFormat: b.timeFormat,
+		Writer:     b.out,
+	}
+	if b.hasLevel {
+		cfg.Level = b.level.String()
+	}
+	if b.color != TriStateAuto {
+		cfg.Color = b.color.String()
+	}
+	if b.caller {
+		cfg.Caller = TriStateYes.String()
+	}
+	return cfg
+}
+
+// Build returns the configured logger.  Unless Global was called, it leaves
+// log.Logger, the global level, and the global output untouched.  The
+// returned logger is still subject to zerolog's globals, though: events
+// below zerolog.GlobalLevel are dropped whatever its own level, and the
+// field names, level marshaling, and hooks added by AddHook all apply.
+func (b *Builder) Build() (*zerolog.Logger, error) {
+	if b.global {
+		if err := Reconfigure(b.Config()); err != nil {
+			return nil, err
+		}
+		return &log.Logger, nil
+	}
+
+	out := b.out
+	if out == nil {
+		out = os.Stderr
+	}
+
+	isTerminal := false
+	if file, ok := out.(*os.File); ok {
+		isTerminal = isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd())
+	}
+	format := b.format
+	if format == "" {
+		format = FormatJSON
+		if isTerminal {
+			format = FormatConsole
+		}
+	}
+	color := b.color.Bool(isTerminal)
+
+	var timeFormat string
+	if b.timeFormat != "" {
+		var err error
+		timeFormat, err = ValidateTimeFormat(b.timeFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// JSON time formats and GCP's severity field are zerolog globals, so
+	// only a global logger can have them.
+	if timeFormat != "" && format != FormatConsole {
+		return nil, fmt.Errorf("time format %q: only console output supports a time format without Global", b.timeFormat)
+	}
+
+	var w io.Writer
+	switch format {
+	case FormatJSON:
+		w = transformWriter{next: out}
+	case FormatJSONPretty:
+		w = transformWriter{next: prettyWriter{next: out}}
+	case FormatConsole:
+		c := &zerolog.ConsoleWriter{
+			Out:        out,
+			NoColor:    !color,
+			TimeFormat: ExpandTimeFormat(defaultConsoleTimeFormat),
+		}
+		switch {
+		case isStrftimeFormat(timeFormat):
+			cp, _ := CompilePattern(timeFormat, Options{})
+			c.FormatTimestamp = consoleTimestamp(cp.Format, time.Local, c.NoColor)
+		case timeFormat != "":
+			c.TimeFormat = timeFormat
+		}
+		w = c
+	default:
+		return nil, fmt.Errorf("unknown log format %q; expected one of [%q, %q, %q] without Global", format, FormatConsole, FormatJSON, FormatJSONPretty)
+	}
+
+	ctx := zerolog.New(w).With().Timestamp()
+	if b.caller {
+		ctx = ctx.Caller()
+	}
+	logger := ctx.Logger().Hook(RequestIDHook{}).Hook(&gHooks)
+	if b.hasLevel {
+		logger = logger.Level(b.level)
+	}
+	return &logger, nil
+}
@@ -0,0 +1,105 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestBuilder(t *testing.T) {
+	resetLevel(t)
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	writerBefore := Writer()
+
+	var buf bytes.Buffer
+	logger, err := New().Level(zerolog.WarnLevel).Output(&buf).Format(FormatJSON).Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	logger.Info().Msg("dropped")
+	logger.Warn().Str("k", "v").Msg("kept")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	if m["level"] != "warn" || m["message"] != "kept" || m["k"] != "v" {
+		t.Errorf("unexpected event: %s", buf.String())
+	}
+
+	buf.Reset()
+	logger, err = New().Output(&buf).Format(FormatConsole).Color(false).TimeFormat("%H:%M").Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	logger.Debug().Msg("hello")
+	line := buf.String()
+	if strings.Contains(line, "\x1b[") || !strings.Contains(line, " DBG hello") || line[2] != ':' {
+		t.Errorf("unexpected console line: %q", line)
+	}
+
+	if Writer() != writerBefore || zerolog.GlobalLevel() != zerolog.TraceLevel {
+		t.Errorf("Build changed global state")
+	}
+
+	for _, b := range []*Builder{
+		New().Format("xml"),
+		New().Format(FormatGCP),
+		New().Format(FormatJSON).TimeFormat("rfc3339"),
+		New().Format(FormatConsole).TimeFormat("bogus"),
+	} {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("%+v: expected error", b.Config())
+		}
+	}
+}
+
+func TestBuilder_GlobalLevel(t *testing.T) {
+	resetLevel(t)
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	// The global level still filters a built logger below it.
+	var buf bytes.Buffer
+	logger, err := New().Level(zerolog.DebugLevel).Output(&buf).Format(FormatJSON).Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	logger.Debug().Msg("dropped")
+	logger.Info().Msg("kept")
+
+	events := decodeLines(t, buf.String())
+	if len(events) != 1 || events[0]["message"] != "kept" {
+		t.Errorf("expected only the info event, got %s", buf.String())
+	}
+}
+
+func TestBuilder_Config(t *testing.T) {
+	cfg := New().Level(zerolog.ErrorLevel).Format(FormatConsole).Color(true).Caller(true).Config()
+	expect := Config{Level: "error", Format: "console", Color: "yes", Caller: "yes"}
+	if cfg != expect {
+		t.Errorf("expected %+v, got %+v", expect, cfg)
+	}
+}
+
+func TestBuilder_Global(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: FormatJSON, Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	logger, err := New().Level(zerolog.InfoLevel).Output(&buf).Format(FormatGCP).Global().Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if logger != &log.Logger {
+		t.Errorf("Global: expected &log.Logger")
+	}
+	log.Info().Msg("hello")
+	if !strings.Contains(buf.String(), `"severity":"INFO"`) {
+		t.Errorf("unexpected event: %s", buf.String())
+	}
+}
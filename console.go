@@ -0,0 +1,169 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// sortedConsoleWriter renders the extra fields of each event in strict key
+// order.  zerolog's ConsoleWriter already sorts them, but hoists "error" to
+// the front; this keeps every column in the same place for golden tests.
+type sortedConsoleWriter struct {
+	cw *zerolog.ConsoleWriter
+}
+
+func (w sortedConsoleWriter) Write(p []byte) (int, error) {
+	var evt map[string]json.RawMessage
+	if err := json.Unmarshal(p, &evt); err != nil {
+		return w.cw.Write(p)
+	}
+
+	cw := *w.cw
+	fields := make([]string, 0, len(evt))
+	for field := range evt {
+		switch field {
+		case zerolog.LevelFieldName, zerolog.TimestampFieldName, zerolog.MessageFieldName, zerolog.CallerFieldName:
+			continue
+		}
+		if contains(cw.FieldsExclude, field) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	cw.FieldsExclude = append(cw.FieldsExclude[:len(cw.FieldsExclude):len(cw.FieldsExclude)], fields...)
+	cw.FormatExtra = func(evt map[string]any, buf *bytes.Buffer) error {
+		for _, field := range fields {
+			if buf.Len() > 0 {
+				buf.WriteByte(' ')
+			}
+			writeConsoleField(buf, &cw, field, evt[field])
+		}
+		if w.cw.FormatExtra != nil {
+			return w.cw.FormatExtra(evt, buf)
+		}
+		return nil
+	}
+	return cw.Write(p)
+}
+
+var _ io.Writer = sortedConsoleWriter{}
+
+// consoleTimestamp renders the console timestamp in loc with format, e.g. a
+// Strftime pattern instead of a Go layout.  It decodes the time field the
+// same way zerolog.ConsoleWriter does.
+func consoleTimestamp(format func(time.Time) string, loc *time.Location, noColor bool) zerolog.Formatter {
+	return func(i any) string {
+		var t time.Time
+		switch v := i.(type) {
+		case string:
+			parsed, err := time.ParseInLocation(zerolog.TimeFieldFormat, v, time.Local)
+			if err != nil {
+				return colorize(v, colorDarkGray, noColor)
+			}
+			t = parsed
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return colorize(v.String(), colorDarkGray, noColor)
+			}
+			switch zerolog.TimeFieldFormat {
+			case zerolog.TimeFormatUnixNano:
+				t = time.Unix(0, n)
+			case zerolog.TimeFormatUnixMicro:
+				t = time.UnixMicro(n)
+			case zerolog.TimeFormatUnixMs:
+				t = time.UnixMilli(n)
+			default:
+				t = time.Unix(n, 0)
+			}
+		default:
+			return colorize("<nil>", colorDarkGray, noColor)
+		}
+		return colorize(format(t.In(loc)), colorDarkGray, noColor)
+	}
+}
+
+// writeConsoleField mirrors the default field formatting of
+// zerolog.ConsoleWriter, honoring any formatters configured on cw.
+func writeConsoleField(buf *bytes.Buffer, cw *zerolog.ConsoleWriter, field string, value any) {
+	isErr := field == zerolog.ErrorFieldName
+
+	fn := cw.FormatFieldName
+	fv := cw.FormatFieldValue
+	if isErr {
+		fn = cw.FormatErrFieldName
+		fv = cw.FormatErrFieldValue
+	}
+	if fn == nil {
+		fn = func(i any) string {
+			return colorize(fmt.Sprintf("%s=", i), colorCyan, cw.NoColor)
+		}
+	}
+	if fv == nil {
+		fv = func(i any) string { return fmt.Sprintf("%s", i) }
+		if isErr {
+			fv = func(i any) string {
+				return colorize(colorize(fmt.Sprintf("%s", i), colorBold, cw.NoColor), colorRed, cw.NoColor)
+			}
+		}
+	}
+
+	buf.WriteString(fn(field))
+	switch v := value.(type) {
+	case string:
+		if needsQuote(v) {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(fv(v))
+	case json.Number:
+		buf.WriteString(fv(v))
+	default:
+		raw, err := zerolog.InterfaceMarshalFunc(v)
+		if err != nil {
+			fmt.Fprintf(buf, colorize("[error: %v]", colorRed, cw.NoColor), err)
+		} else {
+			buf.WriteString(fv(raw))
+		}
+	}
+}
+
+const (
+	colorRed      = 31
+	colorCyan     = 36
+	colorDarkGray = 90
+	colorBold     = 1
+)
+
+func colorize(s any, c int, disabled bool) string {
+	if disabled {
+		return fmt.Sprintf("%s", s)
+	}
+	return fmt.Sprintf("\x1b[%dm%v\x1b[0m", c, s)
+}
+
+func needsQuote(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e || s[i] == ' ' || s[i] == '\\' || s[i] == '"' {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, item string) bool {
+	for _, x := range list {
+		if x == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package autolog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestReconfigure_ConsoleSortFields(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	emit := func(sortFields string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		cfg := Config{Format: "console", Color: "no", ConsoleSortFields: sortFields, Writer: &buf}
+		if err := Reconfigure(cfg); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		log.Info().
+			Str("zeta", "last one").
+			Err(errors.New("boom")).
+			Int("alpha", 1).
+			Dict("mid", zerolog.Dict().Int("b", 1).Int("a", 2)).
+			Msg("hello")
+		out := buf.String()
+		i := strings.Index(out, "hello ")
+		if i < 0 {
+			t.Fatalf("message missing from output: %q", out)
+		}
+		return out[i+len("hello "):]
+	}
+
+	expect := "alpha=1 error=boom mid={\"a\":2,\"b\":1} zeta=\"last one\"\n"
+	for i := 0; i < 10; i++ {
+		if actual := emit("yes"); actual != expect {
+			t.Fatalf("sorted: wrong fields:\n\texpect: %q\n\tactual: %q", expect, actual)
+		}
+	}
+
+	expect = "error=boom alpha=1 mid={\"a\":2,\"b\":1} zeta=\"last one\"\n"
+	if actual := emit("no"); actual != expect {
+		t.Errorf("default: wrong fields:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+}
@@ -0,0 +1,40 @@
+package autolog
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// WithContext returns a copy of ctx carrying a child of the context's logger
+// (or of the default logger) with fields attached to every event.
+func WithContext(ctx context.Context, fields map[string]any) context.Context {
+	logger := FromContext(ctx).With().Fields(fields).Logger()
+	return logger.WithContext(ctx)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger if
+// there is none.
+func FromContext(ctx context.Context) *zerolog.Logger {
+	return zerolog.Ctx(ctx)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id as its request ID, and a
+// child of the context's logger bound to the new context, so that every
+// event it logs carries a "request_id" field.  See RequestIDHook.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	logger := FromContext(ctx).With().Ctx(ctx).Logger()
+	return logger.WithContext(ctx)
+}
+
+// RequestID returns the request ID stored in ctx by WithRequestID.
+func RequestID(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
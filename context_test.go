@@ -0,0 +1,112 @@
+package autolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestWithContext(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	ctx := WithContext(context.Background(), map[string]any{"request": "abc123", "attempt": 2})
+	ctx = WithContext(ctx, map[string]any{"user": "alice"})
+	FromContext(ctx).Info().Msg("handled")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	expect := map[string]any{"request": "abc123", "attempt": 2.0, "user": "alice", "message": "handled"}
+	for key, value := range expect {
+		if m[key] != value {
+			t.Errorf("field %q: expected %v, got %v", key, value, m[key])
+		}
+	}
+
+	buf.Reset()
+	FromContext(context.Background()).Info().Msg("plain")
+	log.Info().Msg("global")
+	if bytes.Contains(buf.Bytes(), []byte("abc123")) {
+		t.Errorf("context fields leaked into default logger:\n%s", buf.String())
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	requestID := func() any {
+		t.Helper()
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+		}
+		buf.Reset()
+		return m["request_id"]
+	}
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	if id, ok := RequestID(ctx); !ok || id != "req-1" {
+		t.Errorf("RequestID: got (%q, %v)", id, ok)
+	}
+
+	FromContext(ctx).Info().Msg("handled")
+	if id := requestID(); id != "req-1" {
+		t.Errorf("context logger: expected request_id %q, got %v", "req-1", id)
+	}
+
+	// Other helpers keep the ID, and an inner ID replaces the outer one.
+	FromContext(WithContext(ctx, map[string]any{"user": "alice"})).Info().Msg("handled")
+	if id := requestID(); id != "req-1" {
+		t.Errorf("WithContext: expected request_id %q, got %v", "req-1", id)
+	}
+	FromContext(WithRequestID(ctx, "req-2")).Info().Msg("handled")
+	if raw := buf.String(); bytes.Count(buf.Bytes(), []byte(`"request_id"`)) != 1 {
+		t.Errorf("expected exactly one request_id field: %s", raw)
+	}
+	if id := requestID(); id != "req-2" {
+		t.Errorf("nested: expected request_id %q, got %v", "req-2", id)
+	}
+
+	// The global logger is stamped when handed the context explicitly.
+	log.Info().Ctx(ctx).Msg("explicit")
+	if id := requestID(); id != "req-1" {
+		t.Errorf("Event.Ctx: expected request_id %q, got %v", "req-1", id)
+	}
+
+	log.Info().Msg("plain")
+	if id := requestID(); id != nil {
+		t.Errorf("event without a request ID got %v", id)
+	}
+
+	// So are loggers from a Builder, whether carried by the context or
+	// handed it.
+	logger, err := New().Output(&buf).Format(FormatJSON).Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	FromContext(WithRequestID(logger.WithContext(context.Background()), "req-3")).Info().Msg("built")
+	if id := requestID(); id != "req-3" {
+		t.Errorf("Builder via context: expected request_id %q, got %v", "req-3", id)
+	}
+	logger.Info().Ctx(ctx).Msg("built")
+	if id := requestID(); id != "req-1" {
+		t.Errorf("Builder with Event.Ctx: expected request_id %q, got %v", "req-1", id)
+	}
+}
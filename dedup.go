@@ -0,0 +1,135 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// defaultDedupWindow applies to LOG_DEDUP=yes.
+const defaultDedupWindow = 10 * time.Second
+
+// DedupWriter suppresses JSON events whose message repeats the previous
+// event's, for up to a window after the first of them was written.  The
+// last suppressed event is written with " (repeated N times)", or "1 time",
+// appended to its message and a "repeated" field of N when a different
+// message arrives, when a repeat arrives after the window has expired, or
+// on Flush or Close.  Nothing runs in the background, so an expired window
+// alone writes nothing.  Events without a message, and lines that are not
+// JSON, are never suppressed.
+type DedupWriter struct {
+	mu      sync.Mutex
+	next    io.Writer
+	window  time.Duration
+	now     func() time.Time
+	key     string
+	since   time.Time
+	count   int
+	last    []byte
+	lastLvl zerolog.Level
+}
+
+// NewDedupWriter returns a DedupWriter that writes to next.
+func NewDedupWriter(next io.Writer, window time.Duration) *DedupWriter {
+	return &DedupWriter{next: next, window: window, now: time.Now}
+}
+
+func (w *DedupWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *DedupWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	notNil(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	key := eventMessage(p)
+	if key != "" && key == w.key && now.Sub(w.since) < w.window {
+		w.count++
+		w.last = append(w.last[:0], p...)
+		w.lastLvl = level
+		return len(p), nil
+	}
+
+	if err := w.flushLocked(); err != nil {
+		return 0, err
+	}
+	w.key, w.since = key, now
+	if _, err := writeLevel(w.next, level, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes any pending summary.
+func (w *DedupWriter) Flush() error {
+	notNil(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.flushLocked()
+	w.key = ""
+	return err
+}
+
+func (w *DedupWriter) flushLocked() error {
+	if w.count == 0 {
+		return nil
+	}
+	count := w.count
+	w.count = 0
+
+	var fields map[string]any
+	d := json.NewDecoder(bytes.NewReader(w.last))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		return err
+	}
+	times := "times"
+	if count == 1 {
+		times = "time"
+	}
+	fields[zerolog.MessageFieldName] = fmt.Sprintf("%s (repeated %d %s)", w.key, count, times)
+	fields["repeated"] = count
+
+	var out bytes.Buffer
+	e := json.NewEncoder(&out)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(fields); err != nil {
+		return err
+	}
+	_, err := writeLevel(w.next, w.lastLvl, out.Bytes())
+	return err
+}
+
+// Close is Flush.  It does not close the underlying writer.
+func (w *DedupWriter) Close() error {
+	return w.Flush()
+}
+
+var _ zerolog.LevelWriter = (*DedupWriter)(nil)
+
+// eventMessage returns the message of a JSON event, or "" if it has none.
+func eventMessage(p []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return ""
+	}
+	var msg string
+	if err := json.Unmarshal(fields[zerolog.MessageFieldName], &msg); err != nil {
+		return ""
+	}
+	return msg
+}
+
+func writeLevel(w io.Writer, level zerolog.Level, p []byte) (int, error) {
+	if lw, ok := w.(zerolog.LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
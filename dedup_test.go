@@ -0,0 +1,117 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func decodeLines(t *testing.T, data string) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("json.Unmarshal: %v\n%s", err, line)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestDedupWriter(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Unix(1000, 0)
+	w := NewDedupWriter(&buf, time.Minute)
+	w.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		_, _ = w.Write([]byte(`{"level":"error","message":"boom"}` + "\n"))
+	}
+	_, _ = w.Write([]byte(`{"level":"info","message":"other"}` + "\n"))
+	_, _ = w.Write([]byte(`{"level":"info","message":"other"}` + "\n"))
+
+	// Past the window, a repeat is written again after its summary.
+	now = now.Add(2 * time.Minute)
+	_, _ = w.Write([]byte(`{"level":"info","message":"other"}` + "\n"))
+	_, _ = w.Write([]byte("not json\n"))
+	_, _ = w.Write([]byte("not json\n"))
+	_, _ = w.Write([]byte(`{"level":"info","message":"last"}` + "\n"))
+	_, _ = w.Write([]byte(`{"level":"info","message":"last"}` + "\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expect := []string{
+		`{"level":"error","message":"boom"}`,
+		`{"level":"error","message":"boom (repeated 3 times)","repeated":3}`,
+		`{"level":"info","message":"other"}`,
+		`{"level":"info","message":"other (repeated 1 time)","repeated":1}`,
+		`{"level":"info","message":"other"}`,
+		`not json`,
+		`not json`,
+		`{"level":"info","message":"last"}`,
+		`{"level":"info","message":"last (repeated 1 time)","repeated":1}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expect, "\n"), buf.String())
+	}
+}
+
+func TestDedupWriter_Concurrent(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewDedupWriter(&buf, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = w.Write([]byte(`{"message":"same"}` + "\n"))
+			}
+		}()
+	}
+	wg.Wait()
+	_ = w.Close()
+
+	events := decodeLines(t, buf.String())
+	if len(events) != 2 || events[1]["repeated"] != float64(799) {
+		t.Errorf("expected one event and a summary of 799, got %s", buf.String())
+	}
+}
+
+func TestReconfigure_Dedup(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Dedup: "yes", Level: "info", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		log.Error().Msg("flood")
+	}
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+
+	events := decodeLines(t, buf.String())
+	if len(events) != 2 || events[1]["message"] != "flood (repeated 4 times)" || events[1]["level"] != "error" {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	for _, bad := range []string{"0s", "-1m", "often"} {
+		err := Reconfigure(Config{Format: "json", Dedup: bad, Writer: io.Discard})
+		if err == nil || !strings.Contains(err.Error(), LogDedupVarName) {
+			t.Errorf("%s: expected %s error, got %v", bad, LogDedupVarName, err)
+		}
+	}
+}
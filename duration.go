@@ -0,0 +1,131 @@
+package autolog
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StrftimeDuration formats d using the same flag, width, and precision syntax
+// as Strftime, with these conversions:
+//
+//	%H  total hours, not wrapped at 24 (so 26h renders as "26")
+//	%M  minutes within the hour, 00-59
+//	%S  seconds within the minute, 00-59
+//	%N  fractional seconds; precision selects the digit count (default 9)
+//	%s  total whole seconds
+//	%{iso8601}  ISO 8601 duration, e.g. "PT1H30M15.5S"
+//
+// A negative duration renders its sign on %H and %s; the other conversions
+// format the magnitude.
+func StrftimeDuration(pattern string, d time.Duration) string {
+	buf := gPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		gPool.Put(buf)
+	}()
+
+	_ = formatPattern(buf, pattern, durationConverter{d: d})
+	return buf.String()
+}
+
+type durationConverter struct {
+	d time.Duration
+}
+
+func (c durationConverter) abs() (bool, uint64) {
+	if c.d < 0 {
+		return true, uint64(-c.d)
+	}
+	return false, uint64(c.d)
+}
+
+func (c durationConverter) Convert(buf *bytes.Buffer, fs formatState, verb rune) bool {
+	if fs.Colons != 0 {
+		return false
+	}
+
+	neg, u64 := c.abs()
+	switch verb {
+	case 'H':
+		if neg {
+			fs.SetDefaultWidth(3)
+		}
+		fs.SetDefaultWidth(2)
+		fs.formatIntInternal(buf, neg, u64/uint64(time.Hour))
+
+	case 'M':
+		fs.SetDefaultWidth(2)
+		fs.FormatUint(buf, (u64/uint64(time.Minute))%60)
+
+	case 'S':
+		fs.SetDefaultWidth(2)
+		fs.FormatUint(buf, (u64/uint64(time.Second))%60)
+
+	case 'N':
+		digits := uint(9)
+		if fs.HasPrec {
+			digits = min(fs.Prec, 9)
+		}
+		str := strconv.FormatUint(1e9+u64%uint64(time.Second), 10)[1 : 1+digits]
+		fs.HasPrec = false
+		fs.FormatString(buf, str)
+
+	case 's':
+		fs.formatIntInternal(buf, neg, u64/uint64(time.Second))
+
+	default:
+		return false
+	}
+	return true
+}
+
+func (c durationConverter) ConvertNamed(buf *bytes.Buffer, fs formatState, name string) bool {
+	switch name {
+	case "iso8601":
+		fs.FormatString(buf, c.iso8601())
+	default:
+		return false
+	}
+	return true
+}
+
+// iso8601 renders the duration as PT[nH][nM][n[.fff]S], omitting zero
+// components.  Hours are not folded into days, since a day is not always
+// 24 hours long.
+func (c durationConverter) iso8601() string {
+	neg, u64 := c.abs()
+	if u64 == 0 {
+		return "PT0S"
+	}
+
+	hours := u64 / uint64(time.Hour)
+	minutes := (u64 / uint64(time.Minute)) % 60
+	seconds := (u64 / uint64(time.Second)) % 60
+	nanos := u64 % uint64(time.Second)
+
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	b.WriteString("PT")
+	if hours != 0 {
+		b.WriteString(strconv.FormatUint(hours, 10))
+		b.WriteByte('H')
+	}
+	if minutes != 0 {
+		b.WriteString(strconv.FormatUint(minutes, 10))
+		b.WriteByte('M')
+	}
+	if seconds != 0 || nanos != 0 {
+		b.WriteString(strconv.FormatUint(seconds, 10))
+		if nanos != 0 {
+			frac := strconv.FormatUint(1e9+nanos, 10)[1:]
+			b.WriteByte('.')
+			b.WriteString(strings.TrimRight(frac, "0"))
+		}
+		b.WriteByte('S')
+	}
+	return b.String()
+}
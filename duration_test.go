@@ -0,0 +1,48 @@
+package autolog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStrftimeDuration(t *testing.T) {
+	type testCase struct {
+		Duration time.Duration
+		Pattern  string
+		Expect   string
+	}
+
+	testData := [...]testCase{
+		{0, "%H:%M:%S.%.3N", "00:00:00.000"},
+		{250 * time.Millisecond, "%H:%M:%S.%.3N", "00:00:00.250"},
+		{1234567 * time.Microsecond, "%S.%N", "01.234567000"},
+		{1234567 * time.Microsecond, "%S.%.6N", "01.234567"},
+		{12*time.Minute + 34*time.Second + 5*time.Millisecond, "%H:%M:%S.%.3N", "00:12:34.005"},
+		{90 * time.Second, "%s", "90"},
+		{26*time.Hour + 3*time.Minute + 4*time.Second, "%H:%M:%S", "26:03:04"},
+		{100*time.Hour + 59*time.Minute, "%H:%M", "100:59"},
+		{-90 * time.Minute, "%H:%M:%S", "-01:30:00"},
+		{-30 * time.Second, "%H:%M:%S", "-00:00:30"},
+		{5 * time.Second, "%_3S|%-3S|%3H", "__5|5  |000"},
+		{0, "%{iso8601}", "PT0S"},
+		{90 * time.Minute, "%{iso8601}", "PT1H30M"},
+		{15500 * time.Millisecond, "%{iso8601}", "PT15.5S"},
+		{time.Hour + 30*time.Minute + 15*time.Second, "%{iso8601}", "PT1H30M15S"},
+		{27 * time.Hour, "%{iso8601}", "PT27H"},
+		{time.Nanosecond, "%{iso8601}", "PT0.000000001S"},
+		{-90 * time.Second, "%{iso8601}", "-PT1M30S"},
+		{5 * time.Second, "%{bogus}", "%!ERR[braceState, {0 0 0 false false false 0}, \"{bogus}\"]"},
+		{5 * time.Second, "%Q", "%!ERR[percentState, {0 0 0 false false false 0}, 'Q']"},
+	}
+
+	for _, row := range testData {
+		name := fmt.Sprintf("[%v][%s]", row.Duration, row.Pattern)
+		t.Run(name, func(t *testing.T) {
+			actual := StrftimeDuration(row.Pattern, row.Duration)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd)
+
+package autolog
+
+import (
+	"fmt"
+	"os"
+)
+
+func openFD(fd int) (*os.File, error) {
+	return nil, fmt.Errorf("fd %d: file descriptor outputs are not supported on this platform", fd)
+}
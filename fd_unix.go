@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd
+
+package autolog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// openFD duplicates an inherited file descriptor for "fd:<n>" outputs.
+// Closing the log output closes only the duplicate, so the original stays
+// open for whoever passed it in.
+func openFD(fd int) (*os.File, error) {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("fd %d: %w", fd, errno)
+	}
+	if mode := int(flags) & syscall.O_ACCMODE; mode != syscall.O_WRONLY && mode != syscall.O_RDWR {
+		return nil, fmt.Errorf("fd %d: not open for writing", fd)
+	}
+
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, fmt.Errorf("fd %d: dup: %w", fd, err)
+	}
+	syscall.CloseOnExec(dup)
+	return os.NewFile(uintptr(dup), "fd:"+strconv.Itoa(fd)), nil
+}
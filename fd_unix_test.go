@@ -0,0 +1,95 @@
+//go:build linux || darwin || freebsd
+
+package autolog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestReconfigure_FD(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	defer r.Close()
+
+	output := fmt.Sprintf("fd:%d", w.Fd())
+	if err := Reconfigure(Config{Format: "json", Output: output, Level: "info", Strict: "yes"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+
+	// Done closed only the duplicate; the caller's descriptor still works.
+	if _, err := w.WriteString("after\n"); err != nil {
+		t.Errorf("original fd closed by Done: %v", err)
+	}
+	w.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	if !strings.Contains(string(data), `"message":"hello"`) || !strings.HasSuffix(string(data), "after\n") {
+		t.Errorf("unexpected pipe contents: %q", data)
+	}
+
+	for _, bad := range []string{fmt.Sprintf("fd:%d", r.Fd()), "fd:100000", "fd:abc", "fd:-1"} {
+		err := Reconfigure(Config{Format: "json", Output: bad, Strict: "yes"})
+		if err == nil || !strings.Contains(err.Error(), LogOutputVarName) {
+			t.Errorf("%s: expected %s error, got %v", bad, LogOutputVarName, err)
+		}
+	}
+}
+
+func TestReconfigure_FSync(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	isSync := func(file *os.File) bool {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), syscall.F_GETFL, 0)
+		if errno != 0 {
+			t.Fatalf("fcntl: %v", errno)
+		}
+		return int(flags)&syscall.O_SYNC == syscall.O_SYNC
+	}
+
+	dir := t.TempDir()
+	for _, fsync := range []string{"", "no", "yes"} {
+		output := "file:" + filepath.Join(dir, "app"+fsync+".log")
+		if err := Reconfigure(Config{Format: "json", Output: output, FSync: fsync, Strict: "yes"}); err != nil {
+			t.Fatalf("%s=%q: Reconfigure: %v", LogFSyncVarName, fsync, err)
+		}
+		file, ok := Writer().(*os.File)
+		if !ok {
+			t.Fatalf("%s=%q: expected *os.File, got %T", LogFSyncVarName, fsync, Writer())
+		}
+		if expect := fsync == "yes"; isSync(file) != expect {
+			t.Errorf("%s=%q: expected O_SYNC %v", LogFSyncVarName, fsync, expect)
+		}
+	}
+
+	if err := Reconfigure(Config{Format: "json", Output: "pattern:" + filepath.Join(dir, "%Y.log"), FSync: "yes", Strict: "yes"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	rw, ok := Writer().(*RotatingLogWriter)
+	if !ok {
+		t.Fatalf("expected *RotatingLogWriter, got %T", Writer())
+	}
+	if !isSync(rw.file) {
+		t.Errorf("pattern: output was not opened with O_SYNC")
+	}
+}
@@ -0,0 +1,324 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"sync/atomic"
+)
+
+var defaultKeySanitizeRE = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// KeySanitizer rewrites the keys of each JSON event, at every nesting level,
+// replacing each match of Disallowed with an underscore.  Values are left
+// intact.  Writes that are not valid JSON pass through unchanged.
+type KeySanitizer struct {
+	Next       io.Writer
+	Disallowed *regexp.Regexp
+}
+
+func NewKeySanitizer(w io.Writer, disallowed *regexp.Regexp) *KeySanitizer {
+	if disallowed == nil {
+		disallowed = defaultKeySanitizeRE
+	}
+	return &KeySanitizer{Next: w, Disallowed: disallowed}
+}
+
+func (ks *KeySanitizer) Write(p []byte) (int, error) {
+	notNil(ks)
+
+	out, err := rewriteJSONKeys(p, ks.sanitize)
+	if err != nil {
+		return ks.Next.Write(p)
+	}
+	if _, err := ks.Next.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ks *KeySanitizer) sanitize(key string) string {
+	return ks.Disallowed.ReplaceAllLiteralString(key, "_")
+}
+
+var _ io.Writer = (*KeySanitizer)(nil)
+
+type EventTransform func(fields map[string]any) map[string]any
+
+var gTransform atomic.Pointer[EventTransform]
+
+// SetEventTransform installs fn to rewrite every JSON event before it is
+// written, or removes the transform if fn is nil.  Each event is decoded into
+// a map, passed to fn, and re-encoded, so this costs a full JSON round trip
+// per event and the output keys come out sorted.  Numbers are passed to fn
+// as json.Number.  It has no effect on console output.
+func SetEventTransform(fn func(fields map[string]any) map[string]any) {
+	if fn == nil {
+		gTransform.Store(nil)
+		return
+	}
+	t := EventTransform(fn)
+	gTransform.Store(&t)
+}
+
+type transformWriter struct {
+	next io.Writer
+}
+
+func (tw transformWriter) Write(p []byte) (int, error) {
+	fn := gTransform.Load()
+	if fn == nil {
+		return tw.next.Write(p)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	var fields map[string]any
+	if err := d.Decode(&fields); err != nil {
+		return tw.next.Write(p)
+	}
+
+	var out bytes.Buffer
+	e := json.NewEncoder(&out)
+	e.SetEscapeHTML(false)
+	if err := e.Encode((*fn)(fields)); err != nil {
+		return 0, err
+	}
+	if _, err := tw.next.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = transformWriter{}
+
+// prettyWriter re-indents each line of JSON written to it.  Lines that are
+// not valid JSON pass through unchanged.
+type prettyWriter struct {
+	next io.Writer
+}
+
+func (pw prettyWriter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	rest := p
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if err := json.Indent(&out, trimmed, "", "  "); err != nil {
+			out.Write(line)
+			continue
+		}
+		out.WriteByte('\n')
+	}
+	if _, err := pw.next.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = prettyWriter{}
+
+// fieldOrderWriter reorders the top-level keys of each JSON event: the keys
+// in first come first, in that order, and the rest follow alphabetically.
+// Values, including nested objects, are copied verbatim.  Writes that are
+// not a single JSON object pass through unchanged.
+type fieldOrderWriter struct {
+	next  io.Writer
+	first []string
+}
+
+func (fw fieldOrderWriter) Write(p []byte) (int, error) {
+	out, err := reorderJSONKeys(p, fw.first)
+	if err != nil {
+		return fw.next.Write(p)
+	}
+	if _, err := fw.next.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = fieldOrderWriter{}
+
+// reorderJSONKeys re-encodes a single JSON object with its keys ordered as
+// fieldOrderWriter describes.  Duplicate keys are kept, in their original
+// relative order, and the trailing newline (if any) is kept.
+func reorderJSONKeys(p []byte, first []string) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+
+	if tok, err := d.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, errNotObject
+	}
+
+	type member struct {
+		key   string
+		value json.RawMessage
+	}
+	var members []member
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		var m member
+		m.key = tok.(string)
+		if err := d.Decode(&m.value); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	if _, err := d.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errTrailingData
+	}
+
+	rank := func(key string) int {
+		for i, k := range first {
+			if k == key {
+				return i
+			}
+		}
+		return len(first)
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		ri, rj := rank(members[i].key), rank(members[j].key)
+		if ri != rj {
+			return ri < rj
+		}
+		return ri == len(first) && members[i].key < members[j].key
+	})
+
+	var out bytes.Buffer
+	out.Grow(len(p))
+	e := json.NewEncoder(&out)
+	e.SetEscapeHTML(false)
+	out.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		_ = e.Encode(m.key)
+		out.Truncate(out.Len() - 1) // Encode's newline
+		out.WriteByte(':')
+		out.Write(m.value)
+	}
+	out.WriteByte('}')
+	if bytes.HasSuffix(p, []byte{'\n'}) {
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+var (
+	errNotObject    = errors.New("JSON value is not an object")
+	errTrailingData = errors.New("unexpected data after JSON value")
+)
+
+// rewriteJSONKeys re-encodes a single JSON value, passing every object key
+// through fn.  Key order, numbers, and string values are preserved, and the
+// trailing newline (if any) is kept.
+func rewriteJSONKeys(p []byte, fn func(string) string) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+
+	type frame struct {
+		isObject  bool
+		expectKey bool
+		count     int
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(p))
+	var stack []frame
+
+	writeString := func(str string) {
+		var tmp bytes.Buffer
+		e := json.NewEncoder(&tmp)
+		e.SetEscapeHTML(false)
+		_ = e.Encode(str)
+		out.Write(bytes.TrimSuffix(tmp.Bytes(), []byte{'\n'}))
+	}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteRune(rune(delim))
+			if len(stack) > 0 && stack[len(stack)-1].isObject {
+				stack[len(stack)-1].expectKey = true
+			}
+			continue
+		}
+
+		if len(stack) == 0 && out.Len() != 0 {
+			return nil, errTrailingData
+		}
+
+		isKey := false
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.count > 0 && (!top.isObject || top.expectKey) {
+				out.WriteByte(',')
+			}
+			if top.isObject {
+				isKey = top.expectKey
+				top.expectKey = !top.expectKey
+			}
+			if isKey || !top.isObject {
+				top.count++
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(v))
+			stack = append(stack, frame{isObject: v == '{', expectKey: v == '{'})
+			continue
+		case string:
+			if isKey {
+				writeString(fn(v))
+				out.WriteByte(':')
+				continue
+			}
+			writeString(v)
+		case json.Number:
+			out.WriteString(v.String())
+		case bool:
+			if v {
+				out.WriteString("true")
+			} else {
+				out.WriteString("false")
+			}
+		case nil:
+			out.WriteString("null")
+		}
+	}
+
+	if len(stack) != 0 || out.Len() == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if bytes.HasSuffix(p, []byte{'\n'}) {
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
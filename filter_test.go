@@ -0,0 +1,192 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestKeySanitizer(t *testing.T) {
+	type testCase struct {
+		Name       string
+		Disallowed *regexp.Regexp
+		Input      string
+		Expect     string
+	}
+
+	testData := [...]testCase{
+		{
+			Name:   "flat",
+			Input:  `{"http.status code":200,"msg":"http.status code"}` + "\n",
+			Expect: `{"http_status_code":200,"msg":"http.status code"}` + "\n",
+		},
+		{
+			Name:   "nested",
+			Input:  `{"a.b":{"c d":[1,{"e-f":null}],"g":true},"h.i":[{"j.k":"<l.m>"}]}` + "\n",
+			Expect: `{"a_b":{"c_d":[1,{"e_f":null}],"g":true},"h_i":[{"j_k":"<l.m>"}]}` + "\n",
+		},
+		{
+			Name:   "numbers",
+			Input:  `{"x.y":1.50,"z":-3e10,"big":12345678901234567890}`,
+			Expect: `{"x_y":1.50,"z":-3e10,"big":12345678901234567890}`,
+		},
+		{
+			Name:       "custom",
+			Disallowed: regexp.MustCompile(`[.]`),
+			Input:      `{"a.b c":"d.e"}` + "\n",
+			Expect:     `{"a_b c":"d.e"}` + "\n",
+		},
+		{
+			Name:   "not-json",
+			Input:  "plain text.\n",
+			Expect: "plain text.\n",
+		},
+		{
+			Name:   "truncated",
+			Input:  `{"a.b":1`,
+			Expect: `{"a.b":1`,
+		},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ks := NewKeySanitizer(&buf, row.Disallowed)
+			n, err := ks.Write([]byte(row.Input))
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if n != len(row.Input) {
+				t.Errorf("Write: expected n=%d, got %d", len(row.Input), n)
+			}
+			if actual := buf.String(); actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestSetEventTransform(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() {
+		SetEventTransform(nil)
+		_ = Reconfigure(Config{Format: "json", Writer: io.Discard})
+	})
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	severity := map[string]int{"debug": 100, "info": 200, "warn": 400, "error": 500}
+	SetEventTransform(func(fields map[string]any) map[string]any {
+		if level, ok := fields["level"].(string); ok {
+			fields["severity"] = severity[level]
+		}
+		fields["msg"] = fields["message"]
+		delete(fields, "message")
+		return fields
+	})
+
+	log.Warn().Int64("n", 9007199254740993).Msg("careful")
+
+	var m map[string]any
+	d := json.NewDecoder(&buf)
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if m["severity"] != json.Number("400") {
+		t.Errorf("severity: expected 400, got %v", m["severity"])
+	}
+	if m["msg"] != "careful" {
+		t.Errorf("msg: expected %q, got %v", "careful", m["msg"])
+	}
+	if _, found := m["message"]; found {
+		t.Errorf("message field was not renamed: %v", m)
+	}
+	if m["n"] != json.Number("9007199254740993") {
+		t.Errorf("n: lost precision: %v", m["n"])
+	}
+
+	buf.Reset()
+	SetEventTransform(nil)
+	log.Info().Msg("plain")
+	if !strings.Contains(buf.String(), `"message":"plain"`) {
+		t.Errorf("transform still applied after removal:\n%s", buf.String())
+	}
+}
+
+func TestReorderJSONKeys(t *testing.T) {
+	first := []string{"time", "level", "message"}
+
+	type testCase struct {
+		Name   string
+		Input  string
+		Expect string
+	}
+
+	testData := [...]testCase{
+		{"basic", `{"level":"info","zeta":1,"message":"hi","alpha":true,"time":"t"}` + "\n", `{"time":"t","level":"info","message":"hi","alpha":true,"zeta":1}` + "\n"},
+		{"nested", `{"b":{"z":1,"a":[3,{"y":2,"x":1}]},"message":"m","a":null}`, `{"message":"m","a":null,"b":{"z":1,"a":[3,{"y":2,"x":1}]}}`},
+		{"numbers", `{"n":1.50,"message":"<&>"}`, `{"message":"<&>","n":1.50}`},
+		{"duplicates", `{"x":2,"level":"a","x":1}`, `{"level":"a","x":2,"x":1}`},
+		{"not an object", `[1,2]`, `[1,2]`},
+		{"not JSON", "plain text\n", "plain text\n"},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			fw := fieldOrderWriter{next: &buf, first: first}
+			if n, err := fw.Write([]byte(row.Input)); err != nil || n != len(row.Input) {
+				t.Fatalf("Write: got (%d, %v)", n, err)
+			}
+			if actual := buf.String(); actual != row.Expect {
+				t.Errorf("wrong output:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestReconfigure_FieldOrder(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Output: "discard"}) })
+
+	type testCase struct {
+		Config Config
+		Expect string
+	}
+
+	testData := [...]testCase{
+		{Config{Format: "json", FieldOrder: "yes"}, `{"time":_,"level":"info","message":"hello","a":1,"z":2}`},
+		{Config{Format: "gcp", FieldOrder: "yes"}, `{"time":_,"severity":"INFO","message":"hello","a":1,"z":2}`},
+		{Config{Format: "json", FieldOrder: "yes", FieldMessage: "msg"}, `{"time":_,"level":"info","msg":"hello","a":1,"z":2}`},
+		{Config{Format: "json", FieldOrder: "message, z"}, `{"message":"hello","z":2,"a":1,"level":"info","time":_}`},
+		{Config{Format: "json", FieldOrder: "no"}, `{"level":"info","z":2,"a":1,"time":_,"message":"hello"}`},
+	}
+
+	timeRE := regexp.MustCompile(`"time":("[^"]*"|[0-9]+)`)
+	for _, row := range testData {
+		var buf bytes.Buffer
+		row.Config.Writer = &buf
+		if err := Reconfigure(row.Config); err != nil {
+			t.Fatalf("%+v: Reconfigure: %v", row.Config, err)
+		}
+		log.Info().Int("z", 2).Int("a", 1).Msg("hello")
+
+		actual := timeRE.ReplaceAllLiteralString(strings.TrimSpace(buf.String()), `"time":_`)
+		if actual != row.Expect {
+			t.Errorf("%q: wrong output:\n\texpect: %s\n\tactual: %s", row.Config.FieldOrder, row.Expect, actual)
+		}
+	}
+
+	if err := Reconfigure(Config{Format: "json", Output: "discard", FieldOrder: "message,,time"}); err == nil {
+		t.Errorf("expected an error for an empty key")
+	}
+}
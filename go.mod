@@ -4,6 +4,7 @@ go 1.21.1
 
 require (
 	github.com/mattn/go-isatty v0.0.19
+	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.31.0
 )
 
@@ -0,0 +1,89 @@
+package autolog
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// hookList runs the hooks registered with AddHook.  log.Logger always
+// carries gHooks, so hooks added after Init take effect without rebuilding
+// the logger.
+type hookList struct {
+	mu    sync.Mutex
+	hooks atomic.Pointer[[]zerolog.Hook]
+}
+
+var gHooks hookList
+
+// AddHook adds a hook that runs for every event logged through log.Logger
+// and loggers derived from it, including ones created before the call.
+func AddHook(h zerolog.Hook) {
+	gHooks.mu.Lock()
+	defer gHooks.mu.Unlock()
+
+	var hooks []zerolog.Hook
+	if old := gHooks.hooks.Load(); old != nil {
+		hooks = append(hooks, *old...)
+	}
+	hooks = append(hooks, h)
+	gHooks.hooks.Store(&hooks)
+}
+
+func (list *hookList) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if hooks := list.hooks.Load(); hooks != nil {
+		for _, h := range *hooks {
+			h.Run(e, level, msg)
+		}
+	}
+}
+
+var _ zerolog.Hook = (*hookList)(nil)
+
+// HostnameHook adds the machine's hostname to every event as "host".
+type HostnameHook struct{}
+
+var (
+	gHostnameOnce sync.Once
+	gHostname     string
+)
+
+func (HostnameHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	gHostnameOnce.Do(func() {
+		gHostname, _ = os.Hostname()
+	})
+	e.Str("host", gHostname)
+}
+
+var _ zerolog.Hook = HostnameHook{}
+
+// gStartTime approximates the process start time as package initialization.
+var gStartTime = time.Now()
+
+// UptimeHook adds the time since the process started to every event as
+// "uptime".
+type UptimeHook struct{}
+
+func (UptimeHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	e.Dur("uptime", time.Since(gStartTime))
+}
+
+var _ zerolog.Hook = UptimeHook{}
+
+// RequestIDHook adds the request ID of the event's context, as set by
+// WithRequestID, to the event as "request_id".  Events without one are left
+// alone.  Loggers built by this package already run it, so that
+// log.Info().Ctx(ctx) is stamped too; add it only to loggers built
+// elsewhere.
+type RequestIDHook struct{}
+
+func (RequestIDHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if id, ok := RequestID(e.GetCtx()); ok {
+		e.Str("request_id", id)
+	}
+}
+
+var _ zerolog.Hook = RequestIDHook{}
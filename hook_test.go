@@ -0,0 +1,109 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type traceHook struct{}
+
+func (traceHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if msg == "traced" {
+		e.Str("trace_id", "abc123")
+	}
+}
+
+func TestAddHook(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	// A hook added after the logger was built still applies, including to
+	// loggers derived before the call.
+	child := log.With().Str("component", "test").Logger()
+	AddHook(traceHook{})
+
+	for _, logger := range []*zerolog.Logger{&log.Logger, &child} {
+		buf.Reset()
+		logger.Info().Msg("traced")
+
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+		}
+		if m["trace_id"] != "abc123" {
+			t.Errorf("expected trace_id from hook: %s", buf.String())
+		}
+	}
+}
+
+func TestHostnameHook(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("os.Hostname: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf).Hook(HostnameHook{})
+	logger.Info().Msg("hello")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	if m["host"] != host {
+		t.Errorf("expected host %q: %s", host, buf.String())
+	}
+}
+
+func TestReconfigure_PID(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", PID: "yes", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	if m["pid"] != float64(os.Getpid()) {
+		t.Errorf("expected pid %d: %s", os.Getpid(), buf.String())
+	}
+
+	buf.Reset()
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if bytes.Contains(buf.Bytes(), []byte(`"pid"`)) {
+		t.Errorf("pid present after LOG_PID was cleared: %s", buf.String())
+	}
+}
+
+func TestUptimeHook(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf).Hook(UptimeHook{})
+	logger.Info().Msg("hello")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, buf.String())
+	}
+	if uptime, ok := m["uptime"].(float64); !ok || uptime < 0 {
+		t.Errorf("expected non-negative uptime: %s", buf.String())
+	}
+}
@@ -0,0 +1,254 @@
+package autolog
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// layoutToken pairs a Go reference-time layout element with the Strftime
+// conversion that renders identically.  An empty Verb marks an element with
+// no exact equivalent.
+type layoutToken struct {
+	Layout string
+	Verb   string
+}
+
+// layoutTokens is ordered so that longer elements match before their
+// prefixes, as in package time.
+var layoutTokens = [...]layoutToken{
+	{"January", "%B"},
+	{"Jan", "%b"},
+	{"Monday", "%A"},
+	{"Mon", "%a"},
+	{"MST", "%Z"},
+	{"2006", "%Y"},
+	{"-07:00:00", "%::z"},
+	{"-070000", ""},
+	{"-07:00", "%:z"},
+	{"-0700", ""},
+	{"-07", ""},
+	{"Z07:00:00", "%#::z"},
+	{"Z070000", ""},
+	{"Z07:00", "%#z"},
+	{"Z0700", ""},
+	{"Z07", ""},
+	{"002", ""},
+	{"__2", ""},
+	{"_2", "%e"},
+	{"01", "%m"},
+	{"02", "%d"},
+	{"03", "%I"},
+	{"04", "%M"},
+	{"05", "%S"},
+	{"06", "%y"},
+	{"15", "%H"},
+	{"PM", "%p"},
+	{"pm", "%P"},
+	{"1", ""},
+	{"2", ""},
+	{"3", ""},
+	{"4", ""},
+	{"5", ""},
+}
+
+// strftimeLayouts maps each Strftime conversion with an exact Go layout
+// equivalent to that layout.
+var strftimeLayouts = map[string]string{
+	"%D": "01/02/06",
+	"%F": "2006-01-02",
+	"%R": "15:04",
+	"%T": "15:04:05",
+	"%h": "Jan",
+	"%r": "03:04:05 PM",
+	"%%": "%",
+	"%n": "\n",
+	"%t": "\t",
+}
+
+func init() {
+	for _, tok := range layoutTokens {
+		if tok.Verb != "" {
+			strftimeLayouts[tok.Verb] = tok.Layout
+		}
+	}
+}
+
+// GoLayoutToStrftime converts a Go time layout, such as "2006-01-02
+// 15:04:05", to the equivalent Strftime pattern.  It fails for layout
+// elements that no conversion reproduces exactly, such as fractional
+// seconds, "-0700", or unpadded "3".
+func GoLayoutToStrftime(layout string) (string, error) {
+	var sb strings.Builder
+	rest := layout
+	for len(rest) > 0 {
+		if n := fractionLen(rest); n > 0 {
+			return "", fmt.Errorf("layout %q: fractional seconds %q have no strftime equivalent", layout, rest[:n])
+		}
+
+		matched := false
+		for _, tok := range layoutTokens {
+			if !strings.HasPrefix(rest, tok.Layout) {
+				continue
+			}
+			if tok.Verb == "" {
+				return "", fmt.Errorf("layout %q: element %q has no strftime equivalent", layout, tok.Layout)
+			}
+			sb.WriteString(tok.Verb)
+			rest = rest[len(tok.Layout):]
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+
+		if rest[0] == '%' {
+			sb.WriteString("%%")
+		} else {
+			sb.WriteByte(rest[0])
+		}
+		rest = rest[1:]
+	}
+
+	pattern := sb.String()
+	if err := checkLayoutPair(layout, pattern); err != nil {
+		return "", err
+	}
+	return pattern, nil
+}
+
+// layoutGaps describes why common conversions have no Go layout
+// equivalent, for LayoutError messages.
+var layoutGaps = map[string]string{
+	"%C": "century",
+	"%G": "ISO 8601 week-based year",
+	"%g": "two-digit ISO 8601 week-based year",
+	"%U": "week of the year, from Sunday",
+	"%V": "ISO 8601 week of the year",
+	"%W": "week of the year, from Monday",
+	"%j": "day of the year",
+	"%k": "space-padded 24-hour hour",
+	"%l": "space-padded 12-hour hour",
+	"%s": "seconds since the Unix epoch",
+	"%z": "UTC offset, which keeps the seconds of sub-minute zones",
+	"%u": "day of the week, from Monday as 1",
+	"%w": "day of the week, from Sunday as 0",
+}
+
+// LayoutError reports the conversions in a Strftime pattern that have no Go
+// layout equivalent, each listed once, in the order they first appear.
+type LayoutError struct {
+	Pattern string
+	Verbs   []string
+}
+
+func (err *LayoutError) Error() string {
+	var sb strings.Builder
+	for i, verb := range err.Verbs {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(verb)
+		if why, found := layoutGaps[verb]; found {
+			fmt.Fprintf(&sb, " (%s)", why)
+		}
+	}
+	return fmt.Sprintf("pattern %q: no Go layout equivalent for %s", err.Pattern, sb.String())
+}
+
+// StrftimeToGoLayout converts a Strftime pattern, such as "%Y-%m-%d
+// %H:%M:%S", to the equivalent Go time layout.  It fails with a
+// *LayoutError for conversions without an exact Go equivalent, such as %j,
+// %G, or %V, and for flags and widths; and it fails for literal text that
+// Go would read as part of a layout.
+func StrftimeToGoLayout(pattern string) (string, error) {
+	var sb strings.Builder
+	var unsupported []string
+	rest := pattern
+	for len(rest) > 0 {
+		i := strings.IndexByte(rest, '%')
+		if i < 0 {
+			sb.WriteString(rest)
+			break
+		}
+		sb.WriteString(rest[:i])
+		rest = rest[i:]
+
+		n := conversionLen(rest)
+		if n == 0 {
+			return "", &PatternError{Pattern: pattern, Offset: len(pattern) - len(rest)}
+		}
+		verb := rest[:n]
+		rest = rest[n:]
+		if layout, found := strftimeLayouts[verb]; found {
+			sb.WriteString(layout)
+		} else if !slices.Contains(unsupported, verb) {
+			unsupported = append(unsupported, verb)
+		}
+	}
+	if len(unsupported) > 0 {
+		return "", &LayoutError{Pattern: pattern, Verbs: unsupported}
+	}
+
+	layout := sb.String()
+	if err := checkLayoutPair(layout, pattern); err != nil {
+		return "", err
+	}
+	return layout, nil
+}
+
+// conversionLen returns the length of the conversion, with any flags,
+// width, precision, and colons, at the start of str, or 0 if str ends
+// before the conversion does.
+func conversionLen(str string) int {
+	n := 1
+	for n < len(str) && strings.IndexByte("0+_-<>#", str[n]) >= 0 {
+		n++
+	}
+	for n < len(str) && (str[n] == '.' || (str[n] >= '0' && str[n] <= '9')) {
+		n++
+	}
+	for n < len(str) && str[n] == ':' {
+		n++
+	}
+	if n >= len(str) {
+		return 0
+	}
+	if str[n] == '{' {
+		end := strings.IndexByte(str[n:], '}')
+		if end < 0 {
+			return 0
+		}
+		return n + end + 1
+	}
+	return n + 1
+}
+
+// fractionLen returns the length of a fractional-seconds element at the
+// start of str, or 0.
+func fractionLen(str string) int {
+	if len(str) < 2 || (str[0] != '.' && str[0] != ',') || (str[1] != '0' && str[1] != '9') {
+		return 0
+	}
+	n := 2
+	for n < len(str) && str[n] == str[1] {
+		n++
+	}
+	if n < len(str) && str[n] >= '0' && str[n] <= '9' {
+		return 0
+	}
+	return n
+}
+
+// checkLayoutPair confirms that layout and pattern render a time that
+// differs from Go's reference time in every field identically, catching
+// literal text that one side would read as a directive.
+func checkLayoutPair(layout, pattern string) error {
+	expect := Strftime(pattern, layoutCheckTime)
+	actual := layoutCheckTime.Format(layout)
+	if actual != expect {
+		return fmt.Errorf("layout %q and pattern %q disagree: %q != %q", layout, pattern, actual, expect)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package autolog
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoLayoutToStrftime(t *testing.T) {
+	type testCase struct {
+		Layout  string
+		Pattern string
+	}
+
+	// Local mean time in Amsterdam was 19 minutes and 32 seconds ahead.
+	lmt := time.Date(1900, 1, 1, 12, 0, 0, 0, time.FixedZone("LMT", 19*60+32))
+
+	testData := [...]testCase{
+		{"2006-01-02 15:04:05", "%Y-%m-%d %H:%M:%S"},
+		{time.ANSIC, "%a %b %e %H:%M:%S %Y"},
+		{time.RFC822, "%d %b %y %H:%M %Z"},
+		{"Monday, January 02 03:04 PM -07:00", "%A, %B %d %I:%M %p %:z"},
+		{"at 15h: 99%", "at %Hh: 99%%"},
+		{time.RFC3339, "%Y-%m-%dT%H:%M:%S%#z"},
+		{"15:04:05Z07:00:00", "%H:%M:%S%#::z"},
+	}
+
+	for _, row := range testData {
+		pattern, err := GoLayoutToStrftime(row.Layout)
+		if err != nil {
+			t.Errorf("GoLayoutToStrftime(%q): unexpected error: %v", row.Layout, err)
+			continue
+		}
+		if pattern != row.Pattern {
+			t.Errorf("GoLayoutToStrftime(%q): expected %q, got %q", row.Layout, row.Pattern, pattern)
+			continue
+		}
+		layout, err := StrftimeToGoLayout(pattern)
+		if err != nil {
+			t.Errorf("StrftimeToGoLayout(%q): unexpected error: %v", pattern, err)
+			continue
+		}
+		if layout != row.Layout {
+			t.Errorf("StrftimeToGoLayout(%q): expected %q, got %q", pattern, row.Layout, layout)
+		}
+		if expect, actual := lmt.Format(row.Layout), Strftime(pattern, lmt); actual != expect {
+			t.Errorf("%q and %q disagree in a sub-minute zone: %q != %q", row.Layout, pattern, expect, actual)
+		}
+	}
+
+	for _, layout := range []string{time.RFC1123Z, "-0700", time.Kitchen, time.RFC3339Nano, "Z0700", time.StampMilli, "2006-01-02T15:04:05.999", "Jan 2"} {
+		if pattern, err := GoLayoutToStrftime(layout); err == nil {
+			t.Errorf("GoLayoutToStrftime(%q): expected error, got %q", layout, pattern)
+		}
+	}
+}
+
+func TestStrftimeToGoLayout(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Layout  string
+	}
+
+	testData := [...]testCase{
+		{"%F %T", "2006-01-02 15:04:05"},
+		{"%D %R", "01/02/06 15:04"},
+		{"%r", "03:04:05 PM"},
+		{"%h %e%n", "Jan _2\n"},
+		{"%::z", "-07:00:00"},
+	}
+
+	for _, row := range testData {
+		layout, err := StrftimeToGoLayout(row.Pattern)
+		if err != nil {
+			t.Errorf("StrftimeToGoLayout(%q): unexpected error: %v", row.Pattern, err)
+			continue
+		}
+		if layout != row.Layout {
+			t.Errorf("StrftimeToGoLayout(%q): expected %q, got %q", row.Pattern, row.Layout, layout)
+		}
+	}
+
+	for _, pattern := range []string{"%j", "%s", "%k", "%5H", "%-d", "%{epochday}", "day 1: %F", "%Y%"} {
+		if layout, err := StrftimeToGoLayout(pattern); err == nil {
+			t.Errorf("StrftimeToGoLayout(%q): expected error, got %q", pattern, layout)
+		}
+	}
+}
+
+func TestStrftimeToGoLayout_LayoutError(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Verbs   []string
+	}
+
+	testData := [...]testCase{
+		{"%G", []string{"%G"}},
+		{"%V", []string{"%V"}},
+		{"%U", []string{"%U"}},
+		{"%W", []string{"%W"}},
+		{"%G-W%V-%u", []string{"%G", "%V", "%u"}},
+		{"%Y week %U/%W %U", []string{"%U", "%W"}},
+		{"%5H:%M %{epochday}", []string{"%5H", "%{epochday}"}},
+		{"%T %z", []string{"%z"}},
+	}
+
+	for _, row := range testData {
+		_, err := StrftimeToGoLayout(row.Pattern)
+		var layoutErr *LayoutError
+		if !errors.As(err, &layoutErr) {
+			t.Errorf("StrftimeToGoLayout(%q): expected *LayoutError, got %v", row.Pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(layoutErr.Verbs, row.Verbs) {
+			t.Errorf("StrftimeToGoLayout(%q): expected verbs %q, got %q", row.Pattern, row.Verbs, layoutErr.Verbs)
+		}
+		for _, verb := range row.Verbs {
+			if !strings.Contains(err.Error(), verb) {
+				t.Errorf("StrftimeToGoLayout(%q): error %q does not name %s", row.Pattern, err, verb)
+			}
+		}
+	}
+
+	const expect = `pattern "%G-W%V": no Go layout equivalent for %G (ISO 8601 week-based year), %V (ISO 8601 week of the year)`
+	if _, err := StrftimeToGoLayout("%G-W%V"); err == nil || err.Error() != expect {
+		t.Errorf("wrong message:\n\texpect: %s\n\tactual: %v", expect, err)
+	}
+}
@@ -0,0 +1,125 @@
+package autolog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+func SetLevel(level zerolog.Level) {
+	zerolog.SetGlobalLevel(level)
+}
+
+func GetLevel() zerolog.Level {
+	return zerolog.GlobalLevel()
+}
+
+// LevelHandler returns an http.Handler that reports the global log level on
+// GET and changes it on POST or PUT.  The new level is taken from the "level"
+// form value if present, or else from the request body.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(serveLevel)
+}
+
+func serveLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// pass
+
+	case http.MethodPost, http.MethodPut:
+		str := r.FormValue("level")
+		if str == "" {
+			raw, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			str = strings.TrimSpace(string(raw))
+		}
+
+		level, err := parseLevel(str)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetLevel(level)
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = io.WriteString(w, GetLevel().String()+"\n")
+}
+
+// parseLevel is zerolog.ParseLevel, except that it always accepts zerolog's
+// own level names: zerolog.ParseLevel matches the output of
+// LevelFieldMarshalFunc, which LOG_LEVEL_NAMES and the gcp format replace.
+func parseLevel(str string) (zerolog.Level, error) {
+	for l := zerolog.TraceLevel; l <= zerolog.Disabled; l++ {
+		if l != zerolog.NoLevel && str == l.String() {
+			return l, nil
+		}
+	}
+	return zerolog.ParseLevel(str)
+}
+
+// levelNames holds the labels written for each level from trace to panic,
+// with "" meaning zerolog's own name.  It is an array so that loggerShape
+// stays comparable.
+type levelNames [zerolog.PanicLevel - zerolog.TraceLevel + 1]string
+
+// parseLevelNames parses LOG_LEVEL_NAMES: a comma-separated list of "upper"
+// or "lower", which sets the case of every level, and "level=label" pairs,
+// which rename one level, e.g. "upper,warn=WARNING".
+func parseLevelNames(str string) (levelNames, error) {
+	var names levelNames
+	if str == "" {
+		return names, nil
+	}
+
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		key, label, hasLabel := strings.Cut(item, "=")
+		if !hasLabel {
+			var fn func(string) string
+			switch strings.ToLower(item) {
+			case "upper":
+				fn = strings.ToUpper
+			case "lower":
+				fn = strings.ToLower
+			default:
+				return levelNames{}, fmt.Errorf("unknown level case %q; expected \"upper\", \"lower\", or level=label", item)
+			}
+			for i := range names {
+				names[i] = fn(pick(names[i], (zerolog.TraceLevel + zerolog.Level(i)).String()))
+			}
+			continue
+		}
+
+		level, err := parseLevel(strings.TrimSpace(key))
+		if err != nil || level < zerolog.TraceLevel || level > zerolog.PanicLevel {
+			return levelNames{}, fmt.Errorf("unknown level %q", key)
+		}
+		label = strings.TrimSpace(label)
+		if label == "" {
+			return levelNames{}, fmt.Errorf("empty label for level %q", key)
+		}
+		names[level-zerolog.TraceLevel] = label
+	}
+	return names, nil
+}
+
+// marshal is installed as zerolog.LevelFieldMarshalFunc.
+func (names levelNames) marshal(l zerolog.Level) string {
+	if i := int(l - zerolog.TraceLevel); i >= 0 && i < len(names) && names[i] != "" {
+		return names[i]
+	}
+	return l.String()
+}
@@ -0,0 +1,131 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestSetLevel(t *testing.T) {
+	resetLevel(t)
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Writer: &buf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	SetLevel(zerolog.WarnLevel)
+	if actual := GetLevel(); actual != zerolog.WarnLevel {
+		t.Errorf("GetLevel: expected %v, got %v", zerolog.WarnLevel, actual)
+	}
+	log.Info().Msg("filtered")
+
+	SetLevel(zerolog.DebugLevel)
+	log.Info().Msg("unfiltered")
+
+	out := buf.String()
+	if strings.Contains(out, `"message":"filtered"`) {
+		t.Errorf("info message emitted at warn level:\n%s", out)
+	}
+	if !strings.Contains(out, `"message":"unfiltered"`) {
+		t.Errorf("info message missing at debug level:\n%s", out)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	resetLevel(t)
+	SetLevel(zerolog.InfoLevel)
+
+	h := LevelHandler()
+
+	serve := func(method string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/debug/loglevel", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "info\n" {
+		t.Errorf("GET: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = serve(http.MethodPost, "debug")
+	if rec.Code != http.StatusOK || rec.Body.String() != "debug\n" {
+		t.Errorf("POST: got %d %q", rec.Code, rec.Body.String())
+	}
+	if actual := GetLevel(); actual != zerolog.DebugLevel {
+		t.Errorf("POST: expected level %v, got %v", zerolog.DebugLevel, actual)
+	}
+
+	rec = serve(http.MethodPost, "bogus")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST bogus: expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if actual := GetLevel(); actual != zerolog.DebugLevel {
+		t.Errorf("POST bogus: level changed to %v", actual)
+	}
+
+	rec = serve(http.MethodDelete, "")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestReconfigure_LevelNames(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Output: "discard"}) })
+
+	type testCase struct {
+		Names  string
+		Expect []string
+	}
+
+	testData := [...]testCase{
+		{"", []string{"debug", "info", "warn", "error"}},
+		{"upper", []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{"warn=WARNING, error=ERR", []string{"debug", "info", "WARNING", "ERR"}},
+		{"upper,warn=WARNING", []string{"DEBUG", "INFO", "WARNING", "ERROR"}},
+		{"warn=Warning,lower", []string{"debug", "info", "warning", "error"}},
+	}
+
+	for _, row := range testData {
+		var buf bytes.Buffer
+		if err := Reconfigure(Config{Level: "debug", Format: "json", Writer: &buf, LevelNames: row.Names}); err != nil {
+			t.Fatalf("%q: Reconfigure: %v", row.Names, err)
+		}
+		log.Debug().Msg("x")
+		log.Info().Msg("x")
+		log.Warn().Msg("x")
+		log.Error().Msg("x")
+
+		var actual []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var event struct {
+				Level string `json:"level"`
+			}
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("%q: %v", row.Names, err)
+			}
+			actual = append(actual, event.Level)
+		}
+		if strings.Join(actual, ",") != strings.Join(row.Expect, ",") {
+			t.Errorf("%q: expected levels %q, got %q", row.Names, row.Expect, actual)
+		}
+	}
+
+	for _, bad := range []string{"title", "fatal=", "loud=LOUD", "disabled=OFF"} {
+		if err := Reconfigure(Config{Format: "json", Output: "discard", LevelNames: bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	if err := Reconfigure(Config{Format: "gcp", Output: "discard", LevelNames: "upper"}); err == nil {
+		t.Errorf("gcp: expected an error")
+	}
+}
@@ -0,0 +1,48 @@
+package autolog
+
+import (
+	"io"
+	"sync"
+)
+
+// LineWriter passes each Write to the next writer as one complete,
+// newline-terminated line, in a single call, and then flushes the next
+// writer if it buffers.  On a file opened with O_APPEND, this keeps events
+// from different processes sharing the file from interleaving mid-line.
+type LineWriter struct {
+	mu   sync.Mutex
+	next io.Writer
+	buf  []byte
+}
+
+// NewLineWriter returns a LineWriter that writes to next.
+func NewLineWriter(next io.Writer) *LineWriter {
+	return &LineWriter{next: next}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	notNil(w)
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	line := p
+	if p[len(p)-1] != '\n' {
+		w.buf = append(append(w.buf[:0], p...), '\n')
+		line = w.buf
+	}
+	if _, err := w.next.Write(line); err != nil {
+		return 0, err
+	}
+	if f, ok := w.next.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = (*LineWriter)(nil)
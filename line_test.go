@@ -0,0 +1,114 @@
+package autolog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestLineWriter_AppendsNewline(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	w := NewLineWriter(bw)
+
+	for _, p := range []string{`{"a":1}`, "{\"b\":2}\n"} {
+		n, err := w.Write([]byte(p))
+		if err != nil || n != len(p) {
+			t.Fatalf("Write(%q): got (%d, %v)", p, n, err)
+		}
+		// The bufio.Writer is flushed after every line.
+		if bw.Buffered() != 0 {
+			t.Errorf("Write(%q): %d bytes left buffered", p, bw.Buffered())
+		}
+	}
+
+	const expect = "{\"a\":1}\n{\"b\":2}\n"
+	if actual := buf.String(); actual != expect {
+		t.Errorf("wrong output:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+}
+
+func TestLineWriter_ConcurrentAppend(t *testing.T) {
+	const (
+		numWriters = 4
+		numLines   = 200
+	)
+
+	name := filepath.Join(t.TempDir(), "shared.log")
+
+	// Each LineWriter has its own O_APPEND descriptor, as separate
+	// processes would.
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		t.Cleanup(func() { _ = file.Close() })
+
+		w := NewLineWriter(file)
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				padding := bytes.Repeat([]byte("x"), 512)
+				for k := 0; k < numLines; k++ {
+					_, _ = fmt.Fprintf(w, `{"writer":%q,"seq":%d,"pad":%q}`, id, k, padding)
+				}
+			}(fmt.Sprintf("%d.%d", i, j))
+		}
+	}
+	wg.Wait()
+
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(raw) == 0 || raw[len(raw)-1] != '\n' {
+		t.Fatalf("output is not newline-terminated")
+	}
+
+	lines := bytes.Split(raw[:len(raw)-1], []byte("\n"))
+	if len(lines) != numWriters*2*numLines {
+		t.Errorf("expected %d lines, got %d", numWriters*2*numLines, len(lines))
+	}
+	for i, line := range lines {
+		var event struct {
+			Writer string `json:"writer"`
+			Seq    int    `json:"seq"`
+		}
+		if err := json.Unmarshal(line, &event); err != nil || event.Writer == "" {
+			t.Fatalf("line %d is not a complete event: %q", i, line)
+		}
+	}
+}
+
+func TestReconfigure_NDJSON(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Output: "discard"}) })
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := Reconfigure(Config{Format: "json", Writer: bw, NDJSON: "yes"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if Writer() != bw {
+		t.Errorf("Writer: expected the raw output, got %T", Writer())
+	}
+
+	log.Info().Msg("hello")
+	if bw.Buffered() != 0 || !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		t.Errorf("event was not flushed as a complete line: %q", buf.String())
+	}
+
+	if err := Reconfigure(Config{Format: "json", Output: "discard", NDJSON: "maybe"}); err == nil {
+		t.Errorf("expected an error for %s=maybe", LogNDJSONVarName)
+	}
+}
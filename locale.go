@@ -0,0 +1,157 @@
+package autolog
+
+import (
+	"fmt"
+	"time"
+)
+
+type UnitNames struct {
+	One   string
+	Other string
+}
+
+func (u UnitNames) For(n int64) string {
+	if n == 1 {
+		return u.One
+	}
+	return u.Other
+}
+
+type Locale struct {
+	// ShortMonths and LongMonths are indexed by time.Month-1 and are used by
+	// %b/%h and %B, respectively.
+	ShortMonths [12]string
+	LongMonths  [12]string
+
+	// ShortNameWidth and LongNameWidth, if non-zero, are the column widths
+	// of %a/%b/%h and %A/%B, respectively.  Names are left-justified and
+	// padded or truncated to fit unless the pattern gives its own width.
+	ShortNameWidth uint
+	LongNameWidth  uint
+
+	// DateTimeLayout, DateLayout, and TimeLayout are the Go time layouts
+	// for the preferred representations used by %c, %x, and %X.
+	DateTimeLayout string
+	DateLayout     string
+	TimeLayout     string
+
+	// JustNow is used by %{ago} for times within a few seconds of the
+	// reference time.
+	JustNow string
+
+	// Ago and In are fmt patterns wrapping "<count> <unit>" for past and
+	// future times, respectively.
+	Ago string
+	In  string
+
+	Second UnitNames
+	Minute UnitNames
+	Hour   UnitNames
+	Day    UnitNames
+}
+
+var EnglishLocale = Locale{
+	ShortMonths: [12]string{
+		"Jan", "Feb", "Mar", "Apr", "May", "Jun",
+		"Jul", "Aug", "Sep", "Oct", "Nov", "Dec",
+	},
+	LongMonths: [12]string{
+		"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December",
+	},
+	DateTimeLayout: "Mon Jan _2 15:04:05 2006",
+	DateLayout:     "2006-01-02",
+	TimeLayout:     "15:04:05",
+	JustNow:        "just now",
+	Ago:            "%s ago",
+	In:             "in %s",
+	Second:         UnitNames{"second", "seconds"},
+	Minute:         UnitNames{"minute", "minutes"},
+	Hour:           UnitNames{"hour", "hours"},
+	Day:            UnitNames{"day", "days"},
+}
+
+func (loc *Locale) ShortMonth(m time.Month) string {
+	loc = loc.orDefault()
+	return pick(loc.ShortMonths[m-1], EnglishLocale.ShortMonths[m-1])
+}
+
+func (loc *Locale) LongMonth(m time.Month) string {
+	loc = loc.orDefault()
+	return pick(loc.LongMonths[m-1], EnglishLocale.LongMonths[m-1])
+}
+
+func (loc *Locale) nameWidth(long bool) uint {
+	loc = loc.orDefault()
+	if long {
+		return loc.LongNameWidth
+	}
+	return loc.ShortNameWidth
+}
+
+// Layout returns the preferred layout for %c, %x, or %X.
+func (loc *Locale) Layout(verb rune) string {
+	loc = loc.orDefault()
+	switch verb {
+	case 'c':
+		return pick(loc.DateTimeLayout, EnglishLocale.DateTimeLayout)
+	case 'x':
+		return pick(loc.DateLayout, EnglishLocale.DateLayout)
+	case 'X':
+		return pick(loc.TimeLayout, EnglishLocale.TimeLayout)
+	default:
+		return ""
+	}
+}
+
+const justNowThreshold = 5 * time.Second
+
+// Relative describes t relative to ref, e.g. "5 minutes ago" or "in 2 hours".
+// Differences are truncated to the largest whole unit of seconds, minutes,
+// hours, or days.  Fields missing from loc fall back to EnglishLocale.
+func (loc *Locale) Relative(t time.Time, ref time.Time) string {
+	loc = loc.orDefault()
+
+	d := ref.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	if d < justNowThreshold {
+		return pick(loc.JustNow, EnglishLocale.JustNow)
+	}
+
+	var n int64
+	var unit, fallback UnitNames
+	switch {
+	case d < time.Minute:
+		n, unit, fallback = int64(d/time.Second), loc.Second, EnglishLocale.Second
+	case d < time.Hour:
+		n, unit, fallback = int64(d/time.Minute), loc.Minute, EnglishLocale.Minute
+	case d < 24*time.Hour:
+		n, unit, fallback = int64(d/time.Hour), loc.Hour, EnglishLocale.Hour
+	default:
+		n, unit, fallback = int64(d/(24*time.Hour)), loc.Day, EnglishLocale.Day
+	}
+
+	phrase := fmt.Sprintf("%d %s", n, pick(unit.For(n), fallback.For(n)))
+	if future {
+		return fmt.Sprintf(pick(loc.In, EnglishLocale.In), phrase)
+	}
+	return fmt.Sprintf(pick(loc.Ago, EnglishLocale.Ago), phrase)
+}
+
+func (loc *Locale) orDefault() *Locale {
+	if loc == nil {
+		return &EnglishLocale
+	}
+	return loc
+}
+
+func pick(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
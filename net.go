@@ -0,0 +1,144 @@
+package autolog
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minRedialBackoff = 100 * time.Millisecond
+	maxRedialBackoff = 30 * time.Second
+
+	netDialTimeout  = 5 * time.Second
+	netWriteTimeout = 5 * time.Second
+)
+
+// NetWriter writes log lines to a socket.  After a failed write it redials
+// once and retries; if that also fails, the line is dropped and the error
+// returned.  Lines are never buffered across failures.  Failed dials back
+// off exponentially, dropping lines until the next attempt is due.  Dials
+// and writes time out after 5 seconds each, so an unresponsive peer stalls
+// a log call for at most about 15 seconds: a write, a redial, and a retry.
+// UDP is fire-and-forget: write errors are ignored.
+type NetWriter struct {
+	mu      sync.Mutex
+	network string
+	address string
+	conn    net.Conn
+	closed  bool
+	backoff time.Duration
+	retryAt time.Time
+	now     func() time.Time
+
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+// NewNetWriter dials address on network (as for net.Dial) and returns a
+// writer for it.  The initial dial must succeed.
+func NewNetWriter(network, address string) (*NetWriter, error) {
+	w := &NetWriter{
+		network:      network,
+		address:      address,
+		now:          time.Now,
+		dialTimeout:  netDialTimeout,
+		writeTimeout: netWriteTimeout,
+	}
+	conn, err := w.dial()
+	if err != nil {
+		return nil, err
+	}
+	w.conn = conn
+	return w, nil
+}
+
+func (w *NetWriter) dial() (net.Conn, error) {
+	now := w.now()
+	if now.Before(w.retryAt) {
+		return nil, fmt.Errorf("failed to dial %s socket: %q: waiting %v before retrying", w.network, w.address, w.retryAt.Sub(now))
+	}
+
+	conn, err := net.DialTimeout(w.network, w.address, w.dialTimeout)
+	if err != nil {
+		w.backoff *= 2
+		if w.backoff < minRedialBackoff {
+			w.backoff = minRedialBackoff
+		}
+		if w.backoff > maxRedialBackoff {
+			w.backoff = maxRedialBackoff
+		}
+		w.retryAt = now.Add(w.backoff)
+		return nil, fmt.Errorf("failed to dial %s socket: %q: %w", w.network, w.address, err)
+	}
+
+	w.backoff = 0
+	w.retryAt = time.Time{}
+	return conn, nil
+}
+
+func (w *NetWriter) isUDP() bool {
+	return strings.HasPrefix(w.network, "udp")
+}
+
+func (w *NetWriter) Write(p []byte) (int, error) {
+	notNil(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			conn, err := w.dial()
+			if err != nil {
+				return 0, err
+			}
+			w.conn = conn
+		}
+
+		err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+		if err == nil {
+			_, err = w.conn.Write(p)
+		}
+		if err == nil || w.isUDP() {
+			return len(p), nil
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+		lastErr = err
+	}
+	return 0, fmt.Errorf("failed to write to %s socket: %q: %w", w.network, w.address, lastErr)
+}
+
+func (w *NetWriter) Close() error {
+	notNil(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	if err != nil {
+		return fmt.Errorf("failed to close %s socket: %q: %w", w.network, w.address, err)
+	}
+	return nil
+}
+
+var (
+	_ io.Writer = (*NetWriter)(nil)
+	_ io.Closer = (*NetWriter)(nil)
+)
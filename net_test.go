@@ -0,0 +1,310 @@
+package autolog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func acceptLines(t *testing.T, l net.Listener) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func TestReconfigure_UnixOutput(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	name := filepath.Join(t.TempDir(), "log.sock")
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer l.Close()
+
+	if err := Reconfigure(Config{Format: "json", Output: "unix:" + name}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	conn, r := acceptLines(t, l)
+	defer conn.Close()
+
+	log.Info().Msg("one")
+	log.Info().Msg("two")
+	for _, expect := range []string{"one", "two"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString: %v", err)
+		}
+		if !strings.Contains(line, `"message":"`+expect+`"`) {
+			t.Errorf("expected message %q, got %q", expect, line)
+		}
+	}
+
+	if _, ok := Writer().(*NetWriter); !ok {
+		t.Fatalf("expected *NetWriter, got %T", Writer())
+	}
+	if err := Done(); err != nil {
+		t.Errorf("Done: %v", err)
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("expected EOF after Done, got %v", err)
+	}
+}
+
+func TestNetWriter_Reconnect(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log.sock")
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer l.Close()
+
+	w, err := NewNetWriter("unix", name)
+	if err != nil {
+		t.Fatalf("NewNetWriter: %v", err)
+	}
+	defer w.Close()
+
+	conn, _ := acceptLines(t, l)
+	_ = conn.Close()
+
+	// The peer is gone, so the write fails, redials, and retries.
+	if _, err := w.Write([]byte("after\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	conn, r := acceptLines(t, l)
+	defer conn.Close()
+	if line, err := r.ReadString('\n'); err != nil || line != "after\n" {
+		t.Errorf("expected %q, got %q, %v", "after\n", line, err)
+	}
+}
+
+func TestNetWriter_Unixgram(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log.sock")
+	pc, err := net.ListenPacket("unixgram", name)
+	if err != nil {
+		t.Fatalf("net.ListenPacket: %v", err)
+	}
+	defer pc.Close()
+
+	w, err := NewNetWriter("unixgram", name)
+	if err != nil {
+		t.Fatalf("NewNetWriter: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("{\"message\":\"hello\"}\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if actual, expect := string(buf[:n]), "{\"message\":\"hello\"}\n"; actual != expect {
+		t.Errorf("expected %q, got %q", expect, actual)
+	}
+}
+
+func TestReconfigure_TCPOutput(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer l.Close()
+
+	if err := Reconfigure(Config{Format: "json", Output: "tcp://" + l.Addr().String()}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	conn, r := acceptLines(t, l)
+	defer conn.Close()
+
+	log.Info().Msg("hello")
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString: %v", err)
+	}
+	if !strings.Contains(line, `"message":"hello"`) {
+		t.Errorf("unexpected payload: %q", line)
+	}
+}
+
+func TestReconfigure_UDPOutput(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket: %v", err)
+	}
+	defer pc.Close()
+
+	if err := Reconfigure(Config{Format: "json", Output: "udp://" + pc.LocalAddr().String()}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if payload := string(buf[:n]); !strings.Contains(payload, `"message":"hello"`) {
+		t.Errorf("unexpected payload: %q", payload)
+	}
+
+	// With nobody listening, UDP writes still succeed.
+	_ = pc.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := Writer().Write([]byte("{}\n")); err != nil {
+			t.Errorf("Write with no listener: %v", err)
+		}
+	}
+}
+
+func TestNetWriter_TCPReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	addr := l.Addr().String()
+
+	w, err := NewNetWriter("tcp", addr)
+	if err != nil {
+		t.Fatalf("NewNetWriter: %v", err)
+	}
+	defer w.Close()
+
+	conn, _ := acceptLines(t, l)
+	_ = conn.Close()
+	_ = l.Close()
+
+	// Restart the listener on the same port, then keep writing until a line
+	// arrives on a new connection.
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot rebind %s: %v", addr, err)
+	}
+	defer l.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, _ = w.Write([]byte("ping\n"))
+		select {
+		case line := <-lines:
+			if line != "ping\n" {
+				t.Errorf("expected %q, got %q", "ping\n", line)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Fatalf("no line received after the listener restarted")
+}
+
+func TestNetWriter_Backoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	addr := l.Addr().String()
+
+	w, err := NewNetWriter("tcp", addr)
+	if err != nil {
+		t.Fatalf("NewNetWriter: %v", err)
+	}
+	defer w.Close()
+
+	clock := &fakeClock{now: time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)}
+	w.now = clock.Now
+	_ = l.Close()
+	_ = w.conn.Close()
+	w.conn = nil
+
+	expect := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	for _, backoff := range expect {
+		if _, err := w.Write([]byte("x\n")); err == nil {
+			t.Fatalf("Write: expected dial error")
+		}
+		if w.backoff != backoff {
+			t.Errorf("expected backoff %v, got %v", backoff, w.backoff)
+		}
+
+		// Inside the backoff window, writes fail without dialing.
+		clock.Set(clock.Now().Add(backoff / 2))
+		if _, err := w.Write([]byte("x\n")); err == nil || !strings.Contains(err.Error(), "waiting") {
+			t.Errorf("expected backoff error, got %v", err)
+		}
+		clock.Set(clock.Now().Add(backoff))
+	}
+}
+
+func TestNetWriter_WriteTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer l.Close()
+
+	w, err := NewNetWriter("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewNetWriter: %v", err)
+	}
+	defer w.Close()
+	w.writeTimeout = 50 * time.Millisecond
+
+	// The peer accepts but never reads, so the socket buffers fill and
+	// the write stalls until its deadline.
+	peers := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			peers <- conn
+		}
+	}()
+	defer func() {
+		for len(peers) > 0 {
+			_ = (<-peers).Close()
+		}
+	}()
+
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 64<<20)); err == nil {
+		t.Fatalf("expected a write timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("write took %v despite a %v deadline", elapsed, w.writeTimeout)
+	}
+}
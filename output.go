@@ -0,0 +1,200 @@
+package autolog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// outputSpec is a parsed LOG_OUTPUT value.
+type outputSpec struct {
+	scheme string
+	target string
+	query  url.Values
+}
+
+var errBadOutput = errors.New("expected \"stdout\", \"stderr\", \"discard\", \"fd:<n>\", \"file:<path>\", \"pattern:<pattern>\", \"unix:<path>\", \"unixgram:<path>\", \"tcp://<host>:<port>\", or \"udp://<host>:<port>\"")
+
+// outputSchemes lists the schemes that take a target.
+var outputSchemes = map[string]bool{
+	"fd":       true,
+	"file":     true,
+	"pattern":  true,
+	"unix":     true,
+	"unixgram": true,
+	"tcp":      true,
+	"udp":      true,
+}
+
+// parseOutputSpec splits spec into its scheme, target, and query.  Every
+// scheme accepts both "scheme:target" and the URL form "scheme://target",
+// and only the URL form takes query parameters, so that a "?" in a plain
+// path stays part of the path.  In the URL form, file:, unix:, and
+// unixgram: paths must be absolute and are percent-decoded, with an empty
+// or "localhost" host, while pattern: paths are taken as written, since
+// "%" begins a conversion.
+func parseOutputSpec(spec string) (outputSpec, error) {
+	switch spec {
+	case "stdout", "stderr", "discard":
+		return outputSpec{scheme: spec}, nil
+	case "null":
+		return outputSpec{scheme: "discard"}, nil
+	}
+
+	scheme, rest, _ := strings.Cut(spec, ":")
+	if !outputSchemes[scheme] {
+		return outputSpec{}, errBadOutput
+	}
+
+	out := outputSpec{scheme: scheme, target: rest}
+	if rest, ok := strings.CutPrefix(rest, "//"); ok {
+		rest, rawQuery, _ := strings.Cut(rest, "?")
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return outputSpec{}, fmt.Errorf("invalid query %q: %w", rawQuery, err)
+		}
+		out.target, out.query = rest, query
+
+		switch scheme {
+		case "file", "pattern", "unix", "unixgram":
+			host, path, found := strings.Cut(rest, "/")
+			if host != "" && host != "localhost" {
+				return outputSpec{}, fmt.Errorf("unsupported host %q in %s URL", host, scheme)
+			}
+			if !found {
+				return outputSpec{}, fmt.Errorf("missing path in %s URL", scheme)
+			}
+			out.target = "/" + path
+			if scheme != "pattern" {
+				if out.target, err = url.PathUnescape(out.target); err != nil {
+					return outputSpec{}, fmt.Errorf("invalid path %q: %w", path, err)
+				}
+			}
+		}
+	}
+
+	if out.target == "" {
+		return outputSpec{}, fmt.Errorf("missing target after %q", scheme+":")
+	}
+	if scheme == "fd" {
+		if fd, err := strconv.Atoi(out.target); err != nil || fd < 0 {
+			return outputSpec{}, fmt.Errorf("invalid file descriptor %q", out.target)
+		}
+	}
+	return out, nil
+}
+
+// resolveOutput parses spec and applies its query to fo.  Any error is in
+// the spec itself, not in opening the output.
+func resolveOutput(spec string, fo fileOptions) (outputSpec, fileOptions, error) {
+	out, err := parseOutputSpec(spec)
+	if err != nil {
+		return outputSpec{}, fo, err
+	}
+	switch out.scheme {
+	case "file":
+	case "pattern":
+		if _, err := CompilePattern(out.target, Options{}); err != nil {
+			return outputSpec{}, fo, err
+		}
+	default:
+		if len(out.query) != 0 {
+			return outputSpec{}, fo, fmt.Errorf("%s: outputs take no query parameters", out.scheme)
+		}
+		return out, fo, nil
+	}
+	if fo, err = fo.withQuery(out.query); err != nil {
+		return outputSpec{}, fo, err
+	}
+	if out.scheme == "pattern" && fo.existing == ExistingRename {
+		return outputSpec{}, fo, fmt.Errorf("existing-file mode %q is only supported for file: outputs", fo.existing)
+	}
+	return out, fo, nil
+}
+
+// sameFileOutput reports whether a and b are file: or pattern: outputs
+// naming the same path.
+func sameFileOutput(a, b outputSpec) bool {
+	if (a.scheme != "file" && a.scheme != "pattern") || (b.scheme != "file" && b.scheme != "pattern") {
+		return false
+	}
+	return absPath(a.target) == absPath(b.target)
+}
+
+// absPath returns name as an absolute, clean path, or just cleaned if the
+// working directory is unknown.
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
+
+// withQuery returns fo with the query parameters of a file: or pattern:
+// URL applied.  They override the corresponding settings: "mode",
+// "dirmode", "mkdir", "existing", and "fsync".
+func (fo fileOptions) withQuery(query url.Values) (fileOptions, error) {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := query.Get(key)
+		var err error
+		switch key {
+		case "mode":
+			fo.mode, err = parseFileMode(value, fo.mode)
+		case "dirmode":
+			fo.dirMode, err = parseFileMode(value, fo.dirMode)
+		case "mkdir":
+			var enum TriState
+			err = enum.Parse(value)
+			fo.mkdir = enum.Bool(fo.mkdir)
+		case "existing":
+			err = fo.existing.Parse(value)
+		case "fsync":
+			var enum TriState
+			err = enum.Parse(value)
+			fo.sync = enum.Bool(fo.sync)
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return fo, fmt.Errorf("query parameter %q: %w", key, err)
+		}
+	}
+	return fo, nil
+}
+
+// ParseOutput opens the output named by spec, which has the syntax of
+// LOG_OUTPUT, using the default file settings unless the spec's query
+// overrides them, e.g. "file:///var/log/app.log?mode=0640&mkdir=no".
+// needClose reports whether the caller owns the output; if not, as for
+// "stdout", Close does nothing.  If "existing=rename" cannot move an old
+// file aside, it appends to it and logs a warning to the global logger.
+func ParseOutput(spec string) (w io.WriteCloser, needClose bool, err error) {
+	fo := fileOptions{mode: defaultFileMode, mkdir: true, dirMode: defaultDirMode, warn: warnRename}
+	writer, needClose, err := openOutput(Config{Output: spec}, fo)
+	if err != nil {
+		return nil, false, &ConfigError{Variable: LogOutputVarName, Value: spec, Err: err}
+	}
+	if !needClose {
+		return nopWriteCloser{writer}, false, nil
+	}
+	return writer.(io.WriteCloser), true, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
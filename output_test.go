@@ -0,0 +1,187 @@
+package autolog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOutputSpec(t *testing.T) {
+	type testCase struct {
+		Spec   string
+		Scheme string
+		Target string
+		Query  url.Values
+	}
+
+	testData := [...]testCase{
+		{"stdout", "stdout", "", nil},
+		{"stderr", "stderr", "", nil},
+		{"discard", "discard", "", nil},
+		{"null", "discard", "", nil},
+		{"fd:3", "fd", "3", nil},
+		{"fd://3", "fd", "3", url.Values{}},
+		{"file:app.log", "file", "app.log", nil},
+		{"file:/var/log/a?b.log", "file", "/var/log/a?b.log", nil},
+		{"file:///var/log/app.log", "file", "/var/log/app.log", url.Values{}},
+		{"file://localhost/var/log/app%20one.log", "file", "/var/log/app one.log", url.Values{}},
+		{"file:///var/log/app.log?mode=0640&mkdir=true", "file", "/var/log/app.log", url.Values{"mode": {"0640"}, "mkdir": {"true"}}},
+		{"pattern:/var/log/%Y.log", "pattern", "/var/log/%Y.log", nil},
+		{"pattern:///var/log/%Y/%m.log?existing=suffix", "pattern", "/var/log/%Y/%m.log", url.Values{"existing": {"suffix"}}},
+		{"unix:/run/log.sock", "unix", "/run/log.sock", nil},
+		{"unix:///run/log.sock", "unix", "/run/log.sock", url.Values{}},
+		{"unixgram:/dev/log", "unixgram", "/dev/log", nil},
+		{"tcp://127.0.0.1:514", "tcp", "127.0.0.1:514", url.Values{}},
+		{"udp://[::1]:514", "udp", "[::1]:514", url.Values{}},
+		{"tcp:127.0.0.1:514", "tcp", "127.0.0.1:514", nil},
+	}
+
+	for _, row := range testData {
+		spec, err := parseOutputSpec(row.Spec)
+		if err != nil {
+			t.Errorf("parseOutputSpec(%q): unexpected error: %v", row.Spec, err)
+			continue
+		}
+		expect := outputSpec{scheme: row.Scheme, target: row.Target, query: row.Query}
+		if !reflect.DeepEqual(spec, expect) {
+			t.Errorf("parseOutputSpec(%q):\n\texpect: %+v\n\tactual: %+v", row.Spec, expect, spec)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"stdout:",
+		"syslog://localhost",
+		"/var/log/app.log",
+		"file:",
+		"file://",
+		"file://example.com/var/log/app.log",
+		"file:///var/log/%zz.log",
+		"file:///var/log/app.log?mode=%zz",
+		"pattern://",
+		"tcp://",
+	} {
+		if out, err := parseOutputSpec(spec); err == nil {
+			t.Errorf("parseOutputSpec(%q): expected error, got %+v", spec, out)
+		}
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	for _, spec := range []string{"stdout", "stderr", "discard"} {
+		w, needClose, err := ParseOutput(spec)
+		if err != nil || needClose {
+			t.Fatalf("ParseOutput(%q): got (%v, %v)", spec, needClose, err)
+		}
+		// Closing an output the caller does not own is harmless.
+		if err := w.Close(); err != nil {
+			t.Errorf("ParseOutput(%q): Close: %v", spec, err)
+		}
+	}
+	if _, err := os.Stdout.Stat(); err != nil {
+		t.Fatalf("stdout was closed: %v", err)
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "sub", "app.log")
+	w, needClose, err := ParseOutput("file://" + filepath.ToSlash(name) + "?mode=0640&mkdir=yes")
+	if err != nil || !needClose {
+		t.Fatalf("ParseOutput(file): got (%v, %v)", needClose, err)
+	}
+	_, _ = io.WriteString(w, "hello\n")
+	if err := w.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "hello\n" {
+		t.Errorf("ReadFile: got (%q, %v)", data, err)
+	}
+	if fi, err := os.Stat(name); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&^0o640 != 0 {
+		t.Errorf("expected mode 0640 or narrower, got %v", fi.Mode().Perm())
+	}
+
+	w, _, err = ParseOutput("pattern://" + filepath.ToSlash(dir) + "/%Y.log")
+	if err != nil {
+		t.Fatalf("ParseOutput(pattern): %v", err)
+	}
+	rw, ok := w.(*RotatingLogWriter)
+	if !ok {
+		t.Fatalf("ParseOutput(pattern): expected *RotatingLogWriter, got %T", w)
+	}
+	if expect := filepath.Join(dir, time.Now().Format("2006")+".log"); rw.name != expect {
+		t.Errorf("ParseOutput(pattern): expected %q, got %q", expect, rw.name)
+	}
+	_ = w.Close()
+
+	for _, spec := range []string{
+		"bogus:x",
+		"fd:abc",
+		"file://" + filepath.ToSlash(dir) + "/x.log?mode=999",
+		"file://" + filepath.ToSlash(dir) + "/x.log?color=yes",
+		"pattern://" + filepath.ToSlash(dir) + "/%Y.log?existing=rename",
+		"tcp://127.0.0.1:1?mode=0640",
+		"file:" + filepath.Join(dir, "sub", "app.log", "x.log"),
+	} {
+		_, _, err := ParseOutput(spec)
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) || cfgErr.Variable != LogOutputVarName || cfgErr.Value != spec {
+			t.Errorf("ParseOutput(%q): expected %s error, got %v", spec, LogOutputVarName, err)
+		}
+	}
+	if _, _, err := ParseOutput("file://" + filepath.ToSlash(dir) + "/x.log?color=yes"); err == nil || !strings.Contains(err.Error(), `"color"`) {
+		t.Errorf("expected the unknown parameter to be named, got %v", err)
+	}
+}
+
+func TestParseOutput_RenameWarning(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	var logBuf bytes.Buffer
+	if err := Reconfigure(Config{Format: "json", Writer: &logBuf}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	// Every name the old file could be moved to this second or the next
+	// is taken.
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(name, []byte("old\n"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	now := time.Now().UTC()
+	for d := 0; d < 2; d++ {
+		base := name + "." + now.Add(time.Duration(d)*time.Second).Format(time.RFC3339)
+		for i := 0; i <= maxSuffix; i++ {
+			candidate := base
+			if i > 0 {
+				candidate += "-" + strconv.Itoa(i)
+			}
+			if err := os.WriteFile(candidate, nil, 0o666); err != nil {
+				t.Fatalf("os.WriteFile: %v", err)
+			}
+		}
+	}
+
+	w, _, err := ParseOutput("file://" + filepath.ToSlash(name) + "?existing=rename")
+	if err != nil {
+		t.Fatalf("ParseOutput: %v", err)
+	}
+	_, _ = io.WriteString(w, "new\n")
+	_ = w.Close()
+
+	if data, _ := os.ReadFile(name); string(data) != "old\nnew\n" {
+		t.Errorf("expected an append, got %q", data)
+	}
+	if !strings.Contains(logBuf.String(), "failed to move old log file aside") {
+		t.Errorf("expected a warning, got %q", logBuf.String())
+	}
+}
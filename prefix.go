@@ -0,0 +1,105 @@
+package autolog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxPrefixLineBytes bounds the text a TimestampPrefixWriter holds back
+// while waiting for a newline.
+const maxPrefixLineBytes = 16 << 10
+
+// TimestampPrefixWriter prefixes each line written to it with the current
+// time, formatted by a Strftime pattern, and a space.  It holds back any
+// unterminated line until its newline arrives, so lines split across Write
+// calls get one prefix, stamped when they are completed.  Flush writes a
+// held-back line, adding the missing newline.  So that input without
+// newlines, such as a progress bar drawn with "\r", cannot grow memory
+// without bound, a line longer than 16 KiB is broken into several.
+//
+// It suits relaying a subprocess's output; it knows nothing of zerolog.
+type TimestampPrefixWriter struct {
+	mu      sync.Mutex
+	next    io.Writer
+	pattern string
+	now     func() time.Time
+	pending []byte
+	buf     bytes.Buffer
+}
+
+// NewTimestampPrefixWriter returns a TimestampPrefixWriter that writes to
+// next, formatting timestamps with pattern.
+func NewTimestampPrefixWriter(next io.Writer, pattern string) *TimestampPrefixWriter {
+	return &TimestampPrefixWriter{next: next, pattern: pattern, now: time.Now}
+}
+
+func (w *TimestampPrefixWriter) Write(p []byte) (int, error) {
+	notNil(w)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	written := 0
+	for written < len(p) {
+		rest := p[written:]
+		room := maxPrefixLineBytes - len(w.pending)
+		i := bytes.IndexByte(rest, '\n')
+		switch {
+		case i >= 0 && i <= room:
+			if err := w.writeLine(rest[:i+1]); err != nil {
+				return written, err
+			}
+			written += i + 1
+		case i < 0 && len(rest) <= room:
+			w.pending = append(w.pending, rest...)
+			written = len(p)
+		default:
+			w.pending = append(w.pending, rest[:room]...)
+			if err := w.writeLine([]byte{'\n'}); err != nil {
+				return written, err
+			}
+			written += room
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any held-back line, then flushes the next writer if it
+// buffers.
+func (w *TimestampPrefixWriter) Flush() error {
+	notNil(w)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 {
+		if err := w.writeLine([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.next.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// writeLine writes the prefix, the held-back text, and tail, which ends the
+// line, in a single call to the next writer.
+func (w *TimestampPrefixWriter) writeLine(tail []byte) error {
+	w.buf.Reset()
+	_ = StrftimeInto(&w.buf, w.pattern, w.now(), Options{})
+	w.buf.WriteByte(' ')
+	w.buf.Write(w.pending)
+	w.buf.Write(tail)
+	w.pending = w.pending[:0]
+
+	n, err := w.next.Write(w.buf.Bytes())
+	if err == nil && n < w.buf.Len() {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+var _ io.Writer = (*TimestampPrefixWriter)(nil)
@@ -0,0 +1,135 @@
+package autolog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampPrefixWriter_Chunks(t *testing.T) {
+	const input = "first line\nsecond\n\nthird line is longer\npartial"
+
+	for _, size := range []int{1, 2, 3, 5, 11, len(input)} {
+		var buf bytes.Buffer
+		w := NewTimestampPrefixWriter(&buf, "%H:%M:%S")
+		tick := time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)
+		w.now = func() time.Time {
+			tick = tick.Add(time.Second)
+			return tick
+		}
+
+		for i := 0; i < len(input); i += size {
+			chunk := input[i:min(i+size, len(input))]
+			if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+				t.Fatalf("size %d: Write(%q): got (%d, %v)", size, chunk, n, err)
+			}
+		}
+
+		const expect = "08:00:01 first line\n08:00:02 second\n08:00:03 \n08:00:04 third line is longer\n"
+		if actual := buf.String(); actual != expect {
+			t.Errorf("size %d: wrong output:\n\texpect: %q\n\tactual: %q", size, expect, actual)
+		}
+
+		if err := w.Flush(); err != nil {
+			t.Fatalf("size %d: Flush: %v", size, err)
+		}
+		if actual := buf.String(); actual != expect+"08:00:05 partial\n" {
+			t.Errorf("size %d: partial line was not flushed: %q", size, actual)
+		}
+
+		// Nothing is left to flush.
+		if err := w.Flush(); err != nil || buf.Len() != len(expect)+len("08:00:05 partial\n") {
+			t.Errorf("size %d: second Flush wrote more: %q, %v", size, buf.String(), err)
+		}
+	}
+}
+
+func TestTimestampPrefixWriter_OneWritePerLine(t *testing.T) {
+	var rec recordingWriter
+	w := NewTimestampPrefixWriter(&rec, "[%Y]")
+	w.now = func() time.Time { return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	_, _ = w.Write([]byte("a"))
+	_, _ = w.Write([]byte("b\nc\nd"))
+
+	expect := []string{"[2023] ab\n", "[2023] c\n"}
+	if len(rec.writes) != len(expect) {
+		t.Fatalf("expected %d writes, got %q", len(expect), rec.writes)
+	}
+	for i := range expect {
+		if rec.writes[i] != expect[i] {
+			t.Errorf("write %d: expected %q, got %q", i, expect[i], rec.writes[i])
+		}
+	}
+}
+
+func TestTimestampPrefixWriter_Errors(t *testing.T) {
+	w := NewTimestampPrefixWriter(shortWriter{}, "%Y")
+	n, err := w.Write([]byte("x\ny\n"))
+	if n != 0 || !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("short write: got (%d, %v)", n, err)
+	}
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	w = NewTimestampPrefixWriter(bw, "%Y")
+	w.now = func() time.Time { return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) }
+	_, _ = w.Write([]byte("tail"))
+	if err := w.Flush(); err != nil || buf.String() != "2023 tail\n" {
+		t.Errorf("Flush did not reach the underlying buffer: %q, %v", buf.String(), err)
+	}
+}
+
+type recordingWriter struct {
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
+func TestTimestampPrefixWriter_LongLine(t *testing.T) {
+	var rec recordingWriter
+	w := NewTimestampPrefixWriter(&rec, "%Y")
+	w.now = func() time.Time { return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	// Without a newline, held-back text never exceeds the cap.
+	chunk := bytes.Repeat([]byte("x"), 1000)
+	for i := 0; i < 40; i++ {
+		_, _ = w.Write(chunk)
+		if len(w.pending) > maxPrefixLineBytes {
+			t.Fatalf("%d bytes held back", len(w.pending))
+		}
+	}
+	_, _ = w.Write([]byte("\n"))
+
+	total := 0
+	for i, line := range rec.writes {
+		body, ok := strings.CutPrefix(line, "2023 ")
+		if !ok || !strings.HasSuffix(body, "\n") || len(body)-1 > maxPrefixLineBytes {
+			t.Fatalf("write %d is not a capped, prefixed line: %.40q", i, line)
+		}
+		total += len(body) - 1
+	}
+	if total != 40*len(chunk) || len(rec.writes) != 3 {
+		t.Errorf("expected 40000 bytes in 3 lines, got %d in %d", total, len(rec.writes))
+	}
+
+	// A line of exactly the cap is written whole.
+	rec.writes = nil
+	_, _ = w.Write(append(bytes.Repeat([]byte("y"), maxPrefixLineBytes), '\n'))
+	if len(rec.writes) != 1 {
+		t.Errorf("expected 1 write for a line at the cap, got %d", len(rec.writes))
+	}
+}
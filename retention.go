@@ -0,0 +1,306 @@
+package autolog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultPruneInterval = time.Minute
+
+// WithRetention keeps only the newest keep files matching a pattern,
+// removing older ones after each rotation.  Only names the pattern could
+// have produced are candidates, so that "app-%Y%m%d.log" never removes a
+// neighbouring "app-backup.log".  The current file always counts
+// as one of the keep.  Zero or negative keep disables pruning.
+func WithRetention(keep int) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.keep = keep
+	}
+}
+
+// WithPruneInterval limits retention scans to at most one per interval
+// (default one minute).  Rotations inside the interval are coalesced into
+// one prune, run by a timer once the interval elapses, or by Close if that
+// comes first.  A timer-driven prune has no caller to report to, so its
+// errors are discarded.
+func WithPruneInterval(interval time.Duration) RotatingOption {
+	return func(w *RotatingLogWriter) {
+		w.pruneEvery = interval
+	}
+}
+
+func (w *RotatingLogWriter) prune(now time.Time, rotated bool) error {
+	if !w.isPattern || w.keep <= 0 {
+		return nil
+	}
+
+	w.pruneMu.Lock()
+	defer w.pruneMu.Unlock()
+
+	if rotated {
+		w.prunePending = true
+	}
+	if !w.prunePending || w.pruneClosed {
+		return nil
+	}
+	if !w.lastPrune.IsZero() {
+		if wait := w.pruneEvery - now.Sub(w.lastPrune); wait > 0 {
+			if w.pruneTimer == nil {
+				w.pruneTimer = time.AfterFunc(wait, w.pruneLater)
+			}
+			return nil
+		}
+	}
+	return w.pruneLocked(now)
+}
+
+// pruneLocked runs the pending prune.  The caller holds pruneMu.
+func (w *RotatingLogWriter) pruneLocked(now time.Time) error {
+	if w.pruneTimer != nil {
+		w.pruneTimer.Stop()
+		w.pruneTimer = nil
+	}
+	w.prunePending = false
+	w.lastPrune = now
+	return w.pruneNow()
+}
+
+// pruneLater runs a prune that the interval held back.
+func (w *RotatingLogWriter) pruneLater() {
+	w.pruneMu.Lock()
+	defer w.pruneMu.Unlock()
+
+	w.pruneTimer = nil
+	if w.prunePending && !w.pruneClosed {
+		_ = w.pruneLocked(w.now())
+	}
+}
+
+// closePrune runs any pending prune and stops further pruning.  Close calls
+// it while the current file is still open, so that it is not pruned.
+func (w *RotatingLogWriter) closePrune() error {
+	if !w.isPattern || w.keep <= 0 {
+		return nil
+	}
+
+	w.pruneMu.Lock()
+	defer w.pruneMu.Unlock()
+
+	var err error
+	if w.prunePending && !w.pruneClosed {
+		err = w.pruneLocked(w.now())
+	}
+	w.pruneClosed = true
+	return err
+}
+
+func (w *RotatingLogWriter) pruneNow() error {
+	match, err := retentionRegexp(w.pattern)
+	if err != nil {
+		return fmt.Errorf("failed to list old log files: %q: %w", w.pattern, err)
+	}
+	glob := globPattern(w.pattern)
+	matches, err := filepath.Glob(glob)
+	if err != nil {
+		return fmt.Errorf("failed to list old log files: %q: %w", glob, err)
+	}
+
+	var current string
+	_ = w.WithFile(func(name string, _ *os.File) error {
+		current = name
+		return nil
+	})
+
+	type oldFile struct {
+		name    string
+		modTime time.Time
+	}
+
+	old := make([]oldFile, 0, len(matches))
+	for _, name := range matches {
+		if name == current || !match.MatchString(name) {
+			continue
+		}
+		fi, err := os.Lstat(name)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		old = append(old, oldFile{name, fi.ModTime()})
+	}
+
+	sort.Slice(old, func(i, j int) bool {
+		a, b := old[i], old[j]
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.After(b.modTime)
+		}
+		return a.name > b.name
+	})
+
+	var firstErr error
+	for index := w.keep - 1; index < len(old); index++ {
+		if err := os.Remove(old[index].name); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to remove old log file: %q: %w", old[index].name, err)
+		}
+	}
+	return firstErr
+}
+
+// globPattern turns a pattern into a filepath.Glob pattern by replacing
+// every conversion with "*".
+func globPattern(pattern string) string {
+	var buf bytes.Buffer
+	_ = formatPattern(&buf, pattern, globConverter{})
+	return buf.String()
+}
+
+type globConverter struct{}
+
+func (globConverter) Convert(buf *bytes.Buffer, fs formatState, verb rune) bool {
+	buf.WriteByte('*')
+	return true
+}
+
+func (globConverter) ConvertNamed(buf *bytes.Buffer, fs formatState, name string) bool {
+	buf.WriteByte('*')
+	return true
+}
+
+// retentionRegexp returns a regular expression matching the names that
+// pattern can expand to, including the "-<n>" that ExistingSuffix adds
+// before the extension.  globPattern only narrows the directory scan.
+func retentionRegexp(pattern string) (*regexp.Regexp, error) {
+	stem, ext := pattern, filepath.Ext(pattern)
+	if strings.Contains(ext, "%") {
+		ext = ""
+	}
+	stem = strings.TrimSuffix(stem, ext)
+
+	var buf bytes.Buffer
+	var exprs []string
+	if err := formatPattern(&buf, stem, retentionConverter{exprs: &exprs}); err != nil {
+		return nil, err
+	}
+
+	var expr strings.Builder
+	expr.WriteByte('^')
+	for i, literal := range strings.Split(buf.String(), "\x00") {
+		if i > 0 {
+			expr.WriteString(exprs[i-1])
+		}
+		expr.WriteString(regexp.QuoteMeta(literal))
+	}
+	expr.WriteString(`(?:-[0-9]+)?`)
+	expr.WriteString(regexp.QuoteMeta(ext))
+	expr.WriteByte('$')
+	return regexp.Compile(expr.String())
+}
+
+// retentionSamples are the times a conversion is expanded at to learn the
+// shape of its output.  They differ in every field, and in the number of
+// digits of every field that is not zero-padded.
+var retentionSamples = [...]time.Time{
+	time.Date(2006, 1, 2, 3, 4, 5, 6, time.FixedZone("MST", -7*60*60)),
+	time.Date(1999, 12, 28, 23, 59, 58, 987654321, time.UTC),
+}
+
+// retentionConverter writes a NUL in place of each conversion and records a
+// regular expression for the text the conversion can produce.  Numeric
+// fields match exactly as many digits as they are padded to.
+type retentionConverter struct {
+	exprs *[]string
+}
+
+func (c retentionConverter) Convert(buf *bytes.Buffer, fs formatState, verb rune) bool {
+	return c.sample(buf, func(tc timeConverter, out *bytes.Buffer) bool {
+		return tc.Convert(out, fs, verb)
+	})
+}
+
+func (c retentionConverter) ConvertNamed(buf *bytes.Buffer, fs formatState, name string) bool {
+	if strings.HasPrefix(name, "counter:") {
+		buf.WriteByte(0)
+		*c.exprs = append(*c.exprs, `[0-9]+`)
+		return true
+	}
+	return c.sample(buf, func(tc timeConverter, out *bytes.Buffer) bool {
+		return tc.ConvertNamed(out, fs, name)
+	})
+}
+
+func (c retentionConverter) ConvertZulu(buf *bytes.Buffer, fs formatState) bool {
+	return c.sample(buf, func(tc timeConverter, out *bytes.Buffer) bool {
+		return tc.ConvertZulu(out, fs)
+	})
+}
+
+func (c retentionConverter) sample(buf *bytes.Buffer, convert func(timeConverter, *bytes.Buffer) bool) bool {
+	var opts Options
+	var outputs [len(retentionSamples)]string
+	for i, t := range retentionSamples {
+		var out bytes.Buffer
+		if !convert(timeConverter{t: t, opts: &opts, dryRun: true}, &out) {
+			return false
+		}
+		outputs[i] = out.String()
+	}
+	buf.WriteByte(0)
+	*c.exprs = append(*c.exprs, shapeRegexp(outputs[0], outputs[1]))
+	return true
+}
+
+// shapeRegexp returns a regular expression matching a and b, and text of
+// the same shape: digits, letters, and signs may vary, other characters may
+// not.  If a and b differ in length, it falls back to a run of digits, a
+// run of letters, or anything short of a path separator.
+func shapeRegexp(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == len(rb) && len(ra) != 0 {
+		var expr strings.Builder
+		for i := range ra {
+			x, y := ra[i], rb[i]
+			switch {
+			case x == y && !unicode.IsDigit(x) && !unicode.IsLetter(x) && x != '+' && x != '-':
+				expr.WriteString(regexp.QuoteMeta(string(x)))
+			case isDigitOrSpace(x) && isDigitOrSpace(y) && (x == ' ' || y == ' '):
+				expr.WriteString(`[ 0-9]`)
+			case isASCIIDigit(x) && isASCIIDigit(y):
+				expr.WriteString(`[0-9]`)
+			case unicode.IsLetter(x) && unicode.IsLetter(y):
+				expr.WriteString(`\pL`)
+			case (x == '+' || x == '-') && (y == '+' || y == '-'):
+				expr.WriteString(`[+-]`)
+			default:
+				return looseRegexp(a + b)
+			}
+		}
+		return expr.String()
+	}
+	return looseRegexp(a + b)
+}
+
+// looseRegexp returns the fallback of shapeRegexp for the combined samples.
+func looseRegexp(samples string) string {
+	switch {
+	case samples != "" && strings.Trim(samples, "0123456789") == "":
+		return `[0-9]+`
+	case samples != "" && strings.IndexFunc(samples, func(ch rune) bool { return !unicode.IsLetter(ch) }) < 0:
+		return `\pL+`
+	default:
+		return `[^/\\]*`
+	}
+}
+
+func isASCIIDigit(ch rune) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isDigitOrSpace(ch rune) bool {
+	return isASCIIDigit(ch) || ch == ' '
+}
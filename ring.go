@@ -0,0 +1,74 @@
+package autolog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxRingLineBytes bounds the memory used by a single retained line.  Longer
+// lines are truncated.
+const maxRingLineBytes = 16 << 10
+
+// RingWriter retains the most recent lines written to it, e.g. so a panic
+// handler can dump recent context.  Combine it with the real output using
+// io.MultiWriter.
+type RingWriter struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewRingWriter returns a RingWriter that retains the last lines lines.
+func NewRingWriter(lines int) *RingWriter {
+	if lines < 1 {
+		lines = 1
+	}
+	return &RingWriter{lines: make([]string, lines)}
+}
+
+// Write records each line of p, including blank ones.  A final line
+// without a trailing newline is recorded as a line of its own.
+func (w *RingWriter) Write(p []byte) (int, error) {
+	notNil(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A trailing newline ends the last line rather than starting another;
+	// blank lines in between are kept.
+	lines := bytes.Split(p, []byte{'\n'})
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		if len(line) > maxRingLineBytes {
+			line = line[:maxRingLineBytes]
+		}
+		w.lines[w.next] = string(line)
+		w.next++
+		if w.next == len(w.lines) {
+			w.next = 0
+			w.full = true
+		}
+	}
+	return len(p), nil
+}
+
+// Snapshot returns the retained lines, oldest first, without their
+// trailing newlines.
+func (w *RingWriter) Snapshot() []string {
+	notNil(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.full {
+		return append([]string(nil), w.lines[:w.next]...)
+	}
+	out := make([]string, 0, len(w.lines))
+	out = append(out, w.lines[w.next:]...)
+	out = append(out, w.lines[:w.next]...)
+	return out
+}
+
+var _ io.Writer = (*RingWriter)(nil)
@@ -0,0 +1,69 @@
+package autolog
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRingWriter(t *testing.T) {
+	w := NewRingWriter(3)
+	if actual := w.Snapshot(); len(actual) != 0 {
+		t.Errorf("empty: expected no lines, got %q", actual)
+	}
+
+	_, _ = w.Write([]byte("one\n"))
+	_, _ = w.Write([]byte("two\n"))
+	if actual, expect := w.Snapshot(), []string{"one", "two"}; !reflect.DeepEqual(actual, expect) {
+		t.Errorf("partial: expected %q, got %q", expect, actual)
+	}
+
+	for i := 3; i <= 7; i++ {
+		_, _ = fmt.Fprintf(w, "line %d\n", i)
+	}
+	if actual, expect := w.Snapshot(), []string{"line 5", "line 6", "line 7"}; !reflect.DeepEqual(actual, expect) {
+		t.Errorf("wrapped: expected %q, got %q", expect, actual)
+	}
+
+	// One Write may carry several lines.
+	_, _ = w.Write([]byte("a\nb\n"))
+	if actual, expect := w.Snapshot(), []string{"line 7", "a", "b"}; !reflect.DeepEqual(actual, expect) {
+		t.Errorf("multi-line: expected %q, got %q", expect, actual)
+	}
+
+	// Blank lines are lines too.
+	_, _ = w.Write([]byte("a\n\n"))
+	_, _ = w.Write([]byte("\n"))
+	if actual, expect := w.Snapshot(), []string{"a", "", ""}; !reflect.DeepEqual(actual, expect) {
+		t.Errorf("blank lines: expected %q, got %q", expect, actual)
+	}
+
+	_, _ = w.Write([]byte(strings.Repeat("x", 3*maxRingLineBytes) + "\n"))
+	snap := w.Snapshot()
+	if n := len(snap[len(snap)-1]); n != maxRingLineBytes {
+		t.Errorf("long line: expected %d bytes, got %d", maxRingLineBytes, n)
+	}
+}
+
+func TestRingWriter_Concurrent(t *testing.T) {
+	w := NewRingWriter(10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = fmt.Fprintf(w, "%d-%d\n", i, j)
+				_ = w.Snapshot()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(w.Snapshot()); n != 10 {
+		t.Errorf("expected 10 lines, got %d", n)
+	}
+}
@@ -0,0 +1,894 @@
+package autolog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer file.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("bufio.Scanner: %v", err)
+	}
+	return lines
+}
+
+func assertMetaLine(t *testing.T, line string) {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		t.Fatalf("header is not JSON: %q: %v", line, err)
+	}
+	if m["_meta"] != true {
+		t.Errorf("header missing \"_meta\": true: %q", line)
+	}
+	for _, key := range []string{"app", "host", "opened"} {
+		if _, found := m[key]; !found {
+			t.Errorf("header missing %q: %q", key, line)
+		}
+	}
+}
+
+func TestRotatingLogWriter_Header(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	rotated := filepath.Join(dir, "app.log.1")
+
+	w, err := NewRotatingLogWriter(name, false, WithHeader(MetaHeader))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	_, _ = w.Write([]byte("{\"message\":\"one\"}\n"))
+
+	// Reopening a non-empty file must not repeat the header.
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	_, _ = w.Write([]byte("{\"message\":\"two\"}\n"))
+
+	if err := os.Rename(name, rotated); err != nil {
+		t.Fatalf("os.Rename: %v", err)
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	_, _ = w.Write([]byte("{\"message\":\"three\"}\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	old := readLines(t, rotated)
+	if len(old) != 3 {
+		t.Fatalf("expected 3 lines in %s, got %d: %q", rotated, len(old), old)
+	}
+	assertMetaLine(t, old[0])
+
+	cur := readLines(t, name)
+	if len(cur) != 2 {
+		t.Fatalf("expected 2 lines in %s, got %d: %q", name, len(cur), cur)
+	}
+	assertMetaLine(t, cur[0])
+	if cur[1] != `{"message":"three"}` {
+		t.Errorf("unexpected event line: %q", cur[1])
+	}
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func TestRotatingLogWriter_DatedDirectories(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "logs", "%Y", "%m", "%d", "app.log")
+
+	clock := &fakeClock{now: time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC)}
+	w, err := NewRotatingLogWriter(pattern, true, WithMkdir(0o777), WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+	_, _ = w.Write([]byte("before midnight\n"))
+
+	clock.Set(time.Date(2024, 3, 1, 0, 0, 1, 0, time.UTC))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	_, _ = w.Write([]byte("after midnight\n"))
+
+	clock.Set(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	expect := map[string][]string{
+		filepath.Join(dir, "logs", "2024", "02", "29", "app.log"): {"before midnight"},
+		filepath.Join(dir, "logs", "2024", "03", "01", "app.log"): {"after midnight"},
+		filepath.Join(dir, "logs", "2025", "01", "01", "app.log"): nil,
+	}
+	for name, lines := range expect {
+		actual := readLines(t, name)
+		if len(actual) != len(lines) || (len(lines) != 0 && actual[0] != lines[0]) {
+			t.Errorf("%s: expected %q, got %q", name, lines, actual)
+		}
+	}
+}
+
+func TestRotatingLogWriter_NextName(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "app-%Y%m%d-%H.log")
+
+	clock := &fakeClock{now: time.Date(2023, 10, 10, 8, 59, 59, 0, time.UTC)}
+	w, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	currentName := func() string {
+		var name string
+		_ = w.WithFile(func(n string, _ *os.File) error {
+			name = n
+			return nil
+		})
+		return name
+	}
+
+	if next, cur := w.NextName(), currentName(); next != cur {
+		t.Errorf("before rollover: NextName %q != current %q", next, cur)
+	}
+
+	clock.Set(time.Date(2023, 10, 10, 9, 0, 0, 0, time.UTC))
+	expect := filepath.Join(dir, "app-20231010-09.log")
+	next := w.NextName()
+	if next != expect {
+		t.Errorf("NextName: expected %q, got %q", expect, next)
+	}
+	if _, err := os.Stat(next); !os.IsNotExist(err) {
+		t.Errorf("NextName created or found %q: %v", next, err)
+	}
+
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if cur := currentName(); cur != next {
+		t.Errorf("Rotate opened %q, NextName predicted %q", cur, next)
+	}
+
+	later := time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC)
+	if actual, expect := w.NameAt(later), ExpandPath(pattern, later); actual != expect {
+		t.Errorf("NameAt: expected %q, got %q", expect, actual)
+	}
+}
+
+func TestRotatingLogWriter_RotateSameName(t *testing.T) {
+	dir := t.TempDir()
+
+	currentFile := func(w *RotatingLogWriter) *os.File {
+		var file *os.File
+		_ = w.WithFile(func(_ string, f *os.File) error {
+			file = f
+			return nil
+		})
+		return file
+	}
+
+	clock := &fakeClock{now: time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)}
+	w, err := NewRotatingLogWriter(filepath.Join(dir, "app-%Y%m%d.log"), true, WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	f0 := currentFile(w)
+	for _, hour := range []int{9, 23} {
+		clock.Set(time.Date(2023, 10, 10, hour, 0, 0, 0, time.UTC))
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+		if f := currentFile(w); f != f0 {
+			t.Errorf("%02d:00: file was reopened within the same day", hour)
+		}
+	}
+
+	clock.Set(time.Date(2023, 10, 11, 0, 0, 0, 0, time.UTC))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if f := currentFile(w); f == f0 {
+		t.Error("file was not reopened on a new day")
+	}
+
+	// A plain (non-pattern) writer still reopens on every explicit Rotate.
+	plain, err := NewRotatingLogWriter(filepath.Join(dir, "plain.log"), false)
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer plain.Close()
+
+	p0 := currentFile(plain)
+	if err := plain.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if p := currentFile(plain); p == p0 {
+		t.Error("non-pattern writer was not reopened")
+	}
+}
+
+func TestRotatingLogWriter_ISOWeek(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "app-%G-W%V.log")
+	clock := &fakeClock{now: time.Date(2020, 12, 31, 12, 0, 0, 0, time.UTC)}
+
+	w, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	// Each step writes one line at the given time, rotating first.  The
+	// ISO week year differs from the calendar year around both New Years.
+	steps := []struct {
+		now  time.Time
+		line string
+	}{
+		{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "a"},              // Fri, 2020-W53
+		{time.Date(2021, 1, 3, 23, 59, 59, 999999999, time.UTC), "b"},   // Sun, 2020-W53
+		{time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), "c"},              // Mon, 2021-W01
+		{time.Date(2021, 1, 10, 23, 59, 59, 0, time.UTC), "d"},          // Sun, 2021-W01
+		{time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC), "e"},             // Mon, 2021-W02
+		{time.Date(2024, 12, 29, 23, 59, 59, 999999999, time.UTC), "f"}, // Sun, 2024-W52
+		{time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), "g"},            // Mon, 2025-W01
+	}
+	for _, step := range steps {
+		clock.Set(step.now)
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate at %v: %v", step.now, err)
+		}
+		if _, err := w.Write([]byte(step.line + "\n")); err != nil {
+			t.Fatalf("Write at %v: %v", step.now, err)
+		}
+	}
+
+	expect := map[string]string{
+		"app-2020-W53.log": "a,b",
+		"app-2021-W01.log": "c,d",
+		"app-2021-W02.log": "e",
+		"app-2024-W52.log": "f",
+		"app-2025-W01.log": "g",
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if err != nil {
+		t.Fatalf("filepath.Glob: %v", err)
+	}
+	if len(matches) != len(expect) {
+		t.Errorf("expected %d files, got %q", len(expect), matches)
+	}
+	for base, lines := range expect {
+		if actual := strings.Join(readLines(t, filepath.Join(dir, base)), ","); actual != lines {
+			t.Errorf("%s: expected %q, got %q", base, lines, actual)
+		}
+	}
+}
+
+func TestRotatingLogWriter_Existing(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "app-%Y%m%d.log")
+	clock := &fakeClock{now: time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)}
+	name := filepath.Join(dir, "app-20231010.log")
+
+	if err := os.WriteFile(name, []byte("old\n"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	if _, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now), WithExisting(ExistingExclusive)); err == nil {
+		t.Errorf("ExistingExclusive: expected error for existing %q", name)
+	}
+
+	w, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now), WithExisting(ExistingSuffix))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	var actual string
+	_ = w.WithFile(func(n string, _ *os.File) error {
+		actual = n
+		return nil
+	})
+	if expect := filepath.Join(dir, "app-20231010-1.log"); actual != expect {
+		t.Errorf("ExistingSuffix: expected %q, got %q", expect, actual)
+	}
+
+	// Same expansion: Rotate must not walk on to "-2".
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app-20231010-2.log")); !os.IsNotExist(err) {
+		t.Errorf("Rotate opened a new suffix for the same expansion: %v", err)
+	}
+
+	if lines := readLines(t, name); len(lines) != 1 || lines[0] != "old" {
+		t.Errorf("existing file was modified: %q", lines)
+	}
+}
+
+func TestReconfigure_ExistingRename(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	cfg := Config{Format: "json", Output: "file:" + name, Existing: "rename", Strict: "yes"}
+
+	for _, msg := range []string{"first run", "second run"} {
+		if err := Reconfigure(cfg); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+		log.Info().Msg(msg)
+		if err := Done(); err != nil {
+			t.Fatalf("Done: %v", err)
+		}
+	}
+
+	if lines := readLines(t, name); len(lines) != 1 || !strings.Contains(lines[0], "second run") {
+		t.Errorf("expected only the second run in %q, got %q", name, lines)
+	}
+
+	old, err := filepath.Glob(name + ".*")
+	if err != nil || len(old) != 1 {
+		t.Fatalf("expected one renamed file, got %q (%v)", old, err)
+	}
+	stamp := strings.TrimPrefix(old[0], name+".")
+	if _, err := time.Parse(time.RFC3339, stamp); err != nil {
+		t.Errorf("renamed file %q does not end in an RFC 3339 time: %v", old[0], err)
+	}
+	if lines := readLines(t, old[0]); len(lines) != 1 || !strings.Contains(lines[0], "first run") {
+		t.Errorf("expected the first run in %q, got %q", old[0], lines)
+	}
+
+	// Reconfiguring while the file is open is not a restart.
+	if err := Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("third run")
+	cfg.Level = "debug"
+	if err := Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("same run")
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+	if lines := readLines(t, name); len(lines) != 2 || !strings.Contains(lines[1], "same run") {
+		t.Errorf("expected the third run in one file, got %q", lines)
+	}
+	if old, _ := filepath.Glob(name + ".*"); len(old) != 2 {
+		t.Errorf("expected two renamed files, got %q", old)
+	}
+
+	err = Reconfigure(Config{Format: "json", Output: "pattern:" + filepath.Join(dir, "%Y.log"), Existing: "rename"})
+	if err == nil || !strings.Contains(err.Error(), LogExistingVarName) {
+		t.Errorf("pattern: expected %s error, got %v", LogExistingVarName, err)
+	}
+}
+
+func TestRenameAside(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	now := time.Date(2023, 10, 10, 8, 40, 39, 0, time.FixedZone("PDT", -7*60*60))
+	base := name + ".2023-10-10T15:40:39Z"
+
+	for i, expect := range []string{base, base + "-1"} {
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("run %d\n", i)), 0o666); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+		actual, err := renameAside(name, now)
+		if err != nil {
+			t.Fatalf("renameAside: %v", err)
+		}
+		if actual != expect {
+			t.Errorf("renameAside #%d: expected %q, got %q", i, expect, actual)
+		}
+	}
+
+	// Missing files, empty files, and symlinks stay where they are.
+	if actual, err := renameAside(name, now); actual != "" || err != nil {
+		t.Errorf("missing file: got (%q, %v)", actual, err)
+	}
+	if err := os.WriteFile(name, nil, 0o666); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if actual, err := renameAside(name, now); actual != "" || err != nil {
+		t.Errorf("empty file: got (%q, %v)", actual, err)
+	}
+	link := filepath.Join(dir, "link.log")
+	if err := os.Symlink(base, link); err != nil {
+		t.Skipf("os.Symlink: %v", err)
+	}
+	if actual, err := renameAside(link, now); actual != "" || err != nil {
+		t.Errorf("symlink: got (%q, %v)", actual, err)
+	}
+}
+
+func TestExistingMode_Parse(t *testing.T) {
+	type testRow struct {
+		Input  string
+		Expect ExistingMode
+	}
+
+	testData := [...]testRow{
+		{"", ExistingAppend},
+		{"append", ExistingAppend},
+		{"EXCLUSIVE", ExistingExclusive},
+		{"excl", ExistingExclusive},
+		{"suffix", ExistingSuffix},
+		{"Rename", ExistingRename},
+	}
+
+	for _, row := range testData {
+		var mode ExistingMode
+		if err := mode.Parse(row.Input); err != nil {
+			t.Errorf("%q: unexpected error: %v", row.Input, err)
+		} else if mode != row.Expect {
+			t.Errorf("%q: expected %v, got %v", row.Input, row.Expect, mode)
+		}
+	}
+
+	var mode ExistingMode
+	if err := mode.Parse("clobber"); err == nil {
+		t.Errorf("\"clobber\": expected error")
+	}
+}
+
+func TestRotatingLogWriter_RetentionThrottle(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "app-%H%M%S.log")
+
+	t0 := time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	w, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now), WithRetention(1), WithPruneInterval(time.Minute))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	count := func() int {
+		matches, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+		if err != nil {
+			t.Fatalf("filepath.Glob: %v", err)
+		}
+		return len(matches)
+	}
+
+	rotateAt := func(d time.Duration) {
+		t.Helper()
+		clock.Set(t0.Add(d))
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate at +%v: %v", d, err)
+		}
+	}
+
+	// The first rotation prunes immediately.
+	rotateAt(1 * time.Second)
+	if n := count(); n != 1 {
+		t.Errorf("after first rotation: expected 1 file, got %d", n)
+	}
+
+	// Rotations inside the interval do not scan.
+	for i := 2; i <= 10; i++ {
+		rotateAt(time.Duration(i) * time.Second)
+	}
+	if n := count(); n != 10 {
+		t.Errorf("inside interval: expected 10 files, got %d", n)
+	}
+
+	// The first Rotate after the interval prunes the whole backlog.
+	rotateAt(61 * time.Second)
+	if n := count(); n != 1 {
+		t.Errorf("after interval: expected 1 file, got %d", n)
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	type testRow struct {
+		Input  string
+		Expect string
+	}
+
+	testData := [...]testRow{
+		{"app.log", "app.log"},
+		{"app-%Y%m%d.log", "app-***.log"},
+		{"logs/%Y/%m/%d/app-%{counter:x}.log", "logs/*/*/*/app-*.log"},
+		{"100%%.log", "100%.log"},
+	}
+
+	for _, row := range testData {
+		if actual := globPattern(row.Input); actual != row.Expect {
+			t.Errorf("%q: expected %q, got %q", row.Input, row.Expect, actual)
+		}
+	}
+}
+
+func TestRotatingLogWriter_PercentLiteral(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Writer: io.Discard}) })
+
+	dir := t.TempDir()
+	output := "pattern:" + filepath.Join(dir, "cpu-100%%-%Y.log")
+	if err := Reconfigure(Config{Format: "json", Output: output, Strict: "yes"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	log.Info().Msg("hello")
+	if err := Done(); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+
+	expect := filepath.Join(dir, "cpu-100%-"+time.Now().Format("2006")+".log")
+	if lines := readLines(t, expect); len(lines) != 1 {
+		t.Errorf("expected one event in %q, got %q", expect, lines)
+	}
+
+	// A stray "%" is not silently written into the file name.
+	err := Reconfigure(Config{Format: "json", Output: "pattern:" + filepath.Join(dir, "cpu-100%.log"), Strict: "yes"})
+	if err == nil || !strings.Contains(err.Error(), LogOutputVarName) {
+		t.Errorf("stray %%: expected %s error, got %v", LogOutputVarName, err)
+	}
+}
+
+func TestRotatingLogWriter_RolloverIntoNewDirectory(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "logs", "%Y-%m-%d", "app.log")
+	day1 := filepath.Join(dir, "logs", "2023-10-10", "app.log")
+	day2 := filepath.Join(dir, "logs", "2023-10-11", "app.log")
+
+	for _, mkdir := range []bool{true, false} {
+		_ = os.RemoveAll(filepath.Join(dir, "logs"))
+		if err := os.MkdirAll(filepath.Dir(day1), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll: %v", err)
+		}
+
+		clock := &fakeClock{now: time.Date(2023, 10, 10, 23, 59, 59, 0, time.UTC)}
+		opts := []RotatingOption{WithClock(clock.Now)}
+		if mkdir {
+			opts = append(opts, WithMkdir(0o777))
+		}
+		w, err := NewRotatingLogWriter(pattern, true, opts...)
+		if err != nil {
+			t.Fatalf("NewRotatingLogWriter: %v", err)
+		}
+
+		clock.Set(time.Date(2023, 10, 11, 0, 0, 0, 0, time.UTC))
+		err = w.Rotate()
+		_, _ = w.Write([]byte("after\n"))
+		_ = w.Close()
+
+		if mkdir {
+			if err != nil {
+				t.Errorf("WithMkdir: Rotate: %v", err)
+			}
+			if lines := readLines(t, day2); len(lines) != 1 || lines[0] != "after" {
+				t.Errorf("WithMkdir: expected %q in %s, got %q", "after", day2, lines)
+			}
+			continue
+		}
+
+		// Without WithMkdir, Rotate fails and keeps the old file open.
+		if err == nil {
+			t.Errorf("no WithMkdir: expected Rotate to fail for missing %s", filepath.Dir(day2))
+		}
+		if lines := readLines(t, day1); len(lines) != 1 || lines[0] != "after" {
+			t.Errorf("no WithMkdir: expected %q in %s, got %q", "after", day1, lines)
+		}
+	}
+}
+
+func TestRotatingLogWriter_ConcurrentRotateWrite(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "app-%H%M%S.log")
+
+	t0 := time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	w, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+
+	const writers = 8
+	const perWriter = 500
+
+	stop := make(chan struct{})
+	rotated := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				rotated <- n
+				return
+			default:
+			}
+			n++
+			clock.Set(t0.Add(time.Duration(n) * time.Second))
+			if err := w.Rotate(); err != nil {
+				t.Errorf("Rotate: %v", err)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				line := fmt.Sprintf("{\"writer\":%d,\"seq\":%d,\"pad\":%q}\n", i, j, strings.Repeat("x", 100))
+				if _, err := w.Write([]byte(line)); err != nil {
+					t.Errorf("Write: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	if n := <-rotated; n == 0 {
+		t.Errorf("Rotate never ran")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if err != nil {
+		t.Fatalf("filepath.Glob: %v", err)
+	}
+
+	seen := make(map[[2]int]bool, writers*perWriter)
+	for _, name := range matches {
+		for _, line := range readLines(t, name) {
+			var m struct {
+				Writer int
+				Seq    int
+			}
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				t.Fatalf("%s: corrupt line %q: %v", name, line, err)
+			}
+			key := [2]int{m.Writer, m.Seq}
+			if seen[key] {
+				t.Errorf("duplicate line %v", key)
+			}
+			seen[key] = true
+		}
+	}
+	if len(seen) != writers*perWriter {
+		t.Errorf("expected %d lines, found %d across %d files", writers*perWriter, len(seen), len(matches))
+	}
+}
+
+func TestRotatingLogWriter_ClockDayBoundary(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "app-%Y%m%d.log")
+
+	midnight := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	clock := &fakeClock{now: midnight.Add(-time.Second)}
+	w, err := NewRotatingLogWriter(pattern, true, WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	before := filepath.Join(dir, "app-20231231.log")
+	after := filepath.Join(dir, "app-20240101.log")
+
+	type step struct {
+		Now    time.Time
+		Expect string
+	}
+	steps := [...]step{
+		{midnight.Add(-time.Nanosecond), before},
+		{midnight, after},
+		{midnight.Add(time.Hour), after},
+	}
+	for _, s := range steps {
+		clock.Set(s.Now)
+		if actual := w.NextName(); actual != s.Expect {
+			t.Errorf("%s: NextName: expected %q, got %q", s.Now.Format(time.RFC3339Nano), s.Expect, actual)
+		}
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+		var current string
+		_ = w.WithFile(func(name string, _ *os.File) error {
+			current = name
+			return nil
+		})
+		if current != s.Expect {
+			t.Errorf("%s: expected open file %q, got %q", s.Now.Format(time.RFC3339Nano), s.Expect, current)
+		}
+	}
+}
+
+func TestRetentionRegexp(t *testing.T) {
+	type testRow struct {
+		Pattern string
+		Match   []string
+		NoMatch []string
+	}
+
+	testData := [...]testRow{
+		{"app-%Y%m%d.log", []string{"app-20231010.log", "app-20231010-3.log"}, []string{"app-backup.log", "app-error.log", "app-2023101.log", "app-20231010.log.gz"}},
+		{"app-%H%M%S.log", []string{"app-080001.log"}, []string{"app-8001.log"}},
+		{"app-%-d.log", []string{"app-1 .log", "app-31.log"}, []string{"app-1.log"}},
+		{"app-%s.log", []string{"app-1696924800.log", "app-999999999.log"}, []string{"app-.log"}},
+		{"app-%e.log", []string{"app- 1.log", "app-31.log"}, []string{"app-1.log"}},
+		{"app-%F_%z.log", []string{"app-2023-10-10_+0000.log", "app-2023-10-10_-0700.log"}, []string{"app-2023-10-10_UTC.log"}},
+		{"logs/%Y/app-%{counter:x}.log", []string{"logs/2023/app-1.log", "logs/2023/app-12.log"}, []string{"logs/2023/app-.log", "logs/old/app-1.log"}},
+		{"%a-%B.log", []string{"Tue-October.log"}, []string{"Tue-10.log"}},
+		{"100%%.log", []string{"100%.log"}, []string{"1000.log"}},
+	}
+
+	for _, row := range testData {
+		re, err := retentionRegexp(row.Pattern)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", row.Pattern, err)
+			continue
+		}
+		for _, name := range row.Match {
+			if !re.MatchString(name) {
+				t.Errorf("%q: expected %q to match %v", row.Pattern, name, re)
+			}
+		}
+		for _, name := range row.NoMatch {
+			if re.MatchString(name) {
+				t.Errorf("%q: expected %q not to match %v", row.Pattern, name, re)
+			}
+		}
+	}
+}
+
+func TestRotatingLogWriter_RetentionSparesNeighbours(t *testing.T) {
+	dir := t.TempDir()
+	neighbours := []string{"app-backup.log", "app-error.log"}
+	for _, name := range neighbours {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("keep me\n"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+	}
+
+	t0 := time.Date(2023, 10, 10, 8, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	w, err := NewRotatingLogWriter(filepath.Join(dir, "app-%Y%m%d.log"), true, WithClock(clock.Now), WithRetention(1))
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	clock.Set(t0.AddDate(0, 0, 1))
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "app-20231010.log")); !os.IsNotExist(err) {
+		t.Errorf("expected the old log to be pruned, got %v", err)
+	}
+	for _, name := range neighbours {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+}
+
+func TestRotatingLogWriter_PendingPrune(t *testing.T) {
+	count := func(dir string) int {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+		if err != nil {
+			t.Fatalf("filepath.Glob: %v", err)
+		}
+		return len(matches)
+	}
+
+	open := func(dir, counter string, interval time.Duration) *RotatingLogWriter {
+		t.Helper()
+		pattern := filepath.Join(dir, "app-%{counter:"+counter+"}.log")
+		w, err := NewRotatingLogWriter(pattern, true, WithRetention(1), WithPruneInterval(interval))
+		if err != nil {
+			t.Fatalf("NewRotatingLogWriter: %v", err)
+		}
+		// The first rotation prunes at once; the second is held back.
+		for i := 0; i < 2; i++ {
+			if err := w.Rotate(); err != nil {
+				t.Fatalf("Rotate: %v", err)
+			}
+		}
+		if n := count(dir); n != 2 {
+			t.Fatalf("inside interval: expected 2 files, got %d", n)
+		}
+		return w
+	}
+
+	// Without further rotations, a timer runs the held-back prune.
+	dir := t.TempDir()
+	w := open(dir, "pending-timer", 50*time.Millisecond)
+	defer w.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for count(dir) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := count(dir); n != 1 {
+		t.Errorf("after interval: expected 1 file, got %d", n)
+	}
+
+	// Close runs it if the interval has not yet passed.
+	dir = t.TempDir()
+	w = open(dir, "pending-close", time.Hour)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := count(dir); n != 1 {
+		t.Errorf("after Close: expected 1 file, got %d", n)
+	}
+}
+
+func TestRotatingLogWriter_NextNameCounter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewRotatingLogWriter(filepath.Join(dir, "app-%{counter:nextname}.log"), true)
+	if err != nil {
+		t.Fatalf("NewRotatingLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	// Predicting does not advance the counter.
+	expect := filepath.Join(dir, "app-2.log")
+	for i := 0; i < 3; i++ {
+		if actual := w.NextName(); actual != expect {
+			t.Errorf("NextName #%d: expected %q, got %q", i+1, expect, actual)
+		}
+	}
+	if actual := w.NameAt(time.Now().Add(time.Hour)); actual != expect {
+		t.Errorf("NameAt: expected %q, got %q", expect, actual)
+	}
+
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	_ = w.WithFile(func(name string, _ *os.File) error {
+		if name != expect {
+			t.Errorf("Rotate opened %q, NextName predicted %q", name, expect)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+package autolog
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// gSequence is shared by every SequenceWriter, so that numbering continues
+// across Reconfigure.
+var gSequence atomic.Uint64
+
+// SequenceWriter adds a "seq" field to each JSON event, numbering events
+// from 1 in the order they are written, across the whole process.  Numbers
+// are assigned and events written under a lock, so the output of any one
+// SequenceWriter is in sequence order.  Writes that are not a JSON object
+// pass through unnumbered.
+type SequenceWriter struct {
+	mu   sync.Mutex
+	next io.Writer
+	buf  []byte
+}
+
+// NewSequenceWriter returns a SequenceWriter that writes to next.
+func NewSequenceWriter(next io.Writer) *SequenceWriter {
+	return &SequenceWriter{next: next}
+}
+
+func (w *SequenceWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *SequenceWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	notNil(w)
+
+	body := bytes.TrimLeft(p, " \t\r\n")
+	if len(body) == 0 || body[0] != '{' {
+		return writeLevel(w.next, level, p)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rest := body[1:]
+	w.buf = append(w.buf[:0], `{"seq":`...)
+	w.buf = strconv.AppendUint(w.buf, gSequence.Add(1), 10)
+	if trimmed := bytes.TrimLeft(rest, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '}' {
+		w.buf = append(w.buf, ',')
+	}
+	w.buf = append(w.buf, rest...)
+
+	if _, err := writeLevel(w.next, level, w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var _ zerolog.LevelWriter = (*SequenceWriter)(nil)
@@ -0,0 +1,104 @@
+package autolog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestSequenceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewSequenceWriter(&buf)
+
+	base := gSequence.Load()
+	for _, p := range []string{`{"message":"a"}` + "\n", "{}\n", " { } ", "not JSON\n", `[1]`} {
+		if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatalf("Write(%q): got (%d, %v)", p, n, err)
+		}
+	}
+
+	expect := fmt.Sprintf("{\"seq\":%d,\"message\":\"a\"}\n{\"seq\":%d}\n{\"seq\":%d } not JSON\n[1]", base+1, base+2, base+3)
+	if actual := buf.String(); actual != expect {
+		t.Errorf("wrong output:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+}
+
+func TestSequenceWriter_Concurrent(t *testing.T) {
+	const (
+		numWriters    = 2
+		numGoroutines = 4
+		numEvents     = 250
+	)
+
+	bufs := make([]bytes.Buffer, numWriters)
+	base := gSequence.Load()
+
+	var wg sync.WaitGroup
+	for i := range bufs {
+		w := NewSequenceWriter(&bufs[i])
+		for j := 0; j < numGoroutines; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < numEvents; k++ {
+					_, _ = w.Write([]byte(`{"message":"x"}` + "\n"))
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	var all []uint64
+	for i := range bufs {
+		var last uint64
+		for _, line := range strings.Split(strings.TrimSpace(bufs[i].String()), "\n") {
+			var event struct {
+				Seq uint64 `json:"seq"`
+			}
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("writer %d: %v: %q", i, err, line)
+			}
+			if event.Seq <= last {
+				t.Errorf("writer %d: seq %d follows %d", i, event.Seq, last)
+			}
+			last = event.Seq
+			all = append(all, event.Seq)
+		}
+	}
+
+	// Together, the writers used every number exactly once.
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	if len(all) != numWriters*numGoroutines*numEvents {
+		t.Fatalf("expected %d events, got %d", numWriters*numGoroutines*numEvents, len(all))
+	}
+	for i, seq := range all {
+		if seq != base+uint64(i)+1 {
+			t.Fatalf("numbers are not contiguous: expected %d, got %d", base+uint64(i)+1, seq)
+		}
+	}
+}
+
+func TestReconfigure_Seq(t *testing.T) {
+	resetLevel(t)
+	t.Cleanup(func() { _ = Reconfigure(Config{Format: "json", Output: "discard"}) })
+
+	var buf bytes.Buffer
+	if err := Reconfigure(Config{Format: "console", Color: "no", Writer: &buf, Seq: "yes"}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	base := gSequence.Load()
+	log.Info().Msg("one")
+	log.Info().Msg("two")
+
+	for i, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if want := fmt.Sprintf("seq=%d", base+uint64(i)+1); !strings.Contains(line, want) {
+			t.Errorf("line %d: expected %s, got %q", i, want, line)
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 var gPool = sync.Pool{
@@ -14,6 +17,19 @@ var gPool = sync.Pool{
 	},
 }
 
+// timeState holds a timeConverter and the Options it points to, so that
+// StrftimeInto can reuse them rather than allocate both on every call.
+type timeState struct {
+	conv timeConverter
+	opts Options
+}
+
+var gTimePool = sync.Pool{
+	New: func() any {
+		return new(timeState)
+	},
+}
+
 type parseState uint
 
 const (
@@ -22,6 +38,7 @@ const (
 	widthState
 	dotState
 	precState
+	braceState
 )
 
 var pstateNames = [...]string{
@@ -30,6 +47,7 @@ var pstateNames = [...]string{
 	"widthState",
 	"dotState",
 	"precState",
+	"braceState",
 }
 
 func (ps parseState) GoString() string {
@@ -52,6 +70,7 @@ type formatState struct {
 	HasWidth    bool
 	HasPrec     bool
 	JustifyLeft bool
+	Colons      uint
 }
 
 func (fs *formatState) Reset() {
@@ -90,15 +109,14 @@ func (fs formatState) FormatString(buf *bytes.Buffer, value string) {
 		fs.Pad = ' '
 	}
 
+	// Precision and width count runes, so that localized names, such as
+	// "décembre", are neither split mid-rune nor under-padded.
 	if fs.HasPrec {
-		p := fs.Prec
-		if uint(len(value)) > p {
-			value = value[:p]
-		}
+		value = truncateRunes(value, fs.Prec)
 	}
 
 	if fs.HasWidth && !fs.JustifyLeft {
-		n := uint(len(value))
+		n := uint(utf8.RuneCountInString(value))
 		for n < fs.Width {
 			buf.WriteRune(fs.Pad)
 			n++
@@ -108,7 +126,7 @@ func (fs formatState) FormatString(buf *bytes.Buffer, value string) {
 	buf.WriteString(value)
 
 	if fs.HasWidth && fs.JustifyLeft {
-		n := uint(len(value))
+		n := uint(utf8.RuneCountInString(value))
 		for n < fs.Width {
 			buf.WriteRune(fs.Pad)
 			n++
@@ -130,6 +148,18 @@ func (fs formatState) FormatUint(buf *bytes.Buffer, value uint64) {
 	fs.formatIntInternal(buf, false, value)
 }
 
+// formatSmall formats a date or time component in 0-99.  With the default
+// two-digit zero padding it writes the digits directly; anything else goes
+// through FormatUint.
+func (fs formatState) formatSmall(buf *bytes.Buffer, value int) {
+	if value >= 0 && value < 100 && fs.Width == 2 && (fs.Pad == 0 || fs.Pad == '0') && !fs.JustifyLeft {
+		buf.WriteByte(byte('0' + value/10))
+		buf.WriteByte(byte('0' + value%10))
+		return
+	}
+	fs.FormatUint(buf, uint64(value))
+}
+
 func (fs formatState) formatIntInternal(buf *bytes.Buffer, neg bool, value uint64) {
 	fs.SetDefaultPad('0')
 
@@ -168,28 +198,295 @@ func (fs formatState) formatIntInternal(buf *bytes.Buffer, neg bool, value uint6
 	}
 }
 
+type Options struct {
+	// Ref is the reference time for relative directives such as %{ago}.
+	// The zero value means time.Now().
+	Ref time.Time
+
+	// Locale supplies the words used by text directives.  Nil means
+	// EnglishLocale.
+	Locale *Locale
+
+	// FiscalYearStartMonth is the month (1-12) in which the fiscal year
+	// used by %{fy} and %{fq} begins.  Zero means January.  A fiscal year
+	// is numbered by the calendar year in which it begins, so with an April
+	// start, March 2024 falls in FY2023.
+	FiscalYearStartMonth time.Month
+
+	// WeekStart is the first day of the week counted by %{week}, which
+	// numbers weeks like %U does for Sunday and %W for Monday.  The zero
+	// value is Sunday.
+	WeekStart time.Weekday
+
+	// MaxWidth caps the width and precision of each conversion.  Zero means
+	// 4096.  Larger values are clamped or, if StrictWidth is set, rejected
+	// as invalid conversions.
+	MaxWidth    uint
+	StrictWidth bool
+}
+
+func (opts *Options) fiscal(t time.Time) (year int, quarter int, ok bool) {
+	start := opts.FiscalYearStartMonth
+	if start == 0 {
+		start = time.January
+	}
+	if start < time.January || start > time.December {
+		return 0, 0, false
+	}
+
+	year = t.Year()
+	if t.Month() < start {
+		year--
+	}
+	quarter = int((t.Month()-start+12)%12)/3 + 1
+	return year, quarter, true
+}
+
+func (opts *Options) ref() time.Time {
+	if opts.Ref.IsZero() {
+		return time.Now()
+	}
+	return opts.Ref
+}
+
 func Strftime(pattern string, t time.Time) string {
+	return StrftimeWithOptions(pattern, t, Options{})
+}
+
+// StrftimeInLocation formats t as seen in loc, or in UTC if loc is nil.
+func StrftimeInLocation(pattern string, t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return StrftimeWithOptions(pattern, t.In(loc), Options{})
+}
+
+func StrftimeWithOptions(pattern string, t time.Time, opts Options) string {
 	buf := gPool.Get().(*bytes.Buffer)
 	defer func() {
 		buf.Reset()
 		gPool.Put(buf)
 	}()
 
+	_ = StrftimeInto(buf, pattern, t, opts)
+	return buf.String()
+}
+
+// StrftimeInto expands pattern directly into buf, without touching the
+// buffer pool, and returns the first invalid conversion as a *PatternError.
+// It is meant for ConversionFuncs that expand a sub-pattern into the buffer
+// they were handed.
+func StrftimeInto(buf *bytes.Buffer, pattern string, t time.Time, opts Options) error {
+	ts := gTimePool.Get().(*timeState)
+	ts.opts = opts
+	ts.conv = timeConverter{t: t, opts: &ts.opts}
+	err := formatPattern(buf, pattern, &ts.conv)
+	*ts = timeState{}
+	gTimePool.Put(ts)
+	return err
+}
+
+// ConversionFunc writes the expansion of a custom %{name} conversion to buf.
+// Width, padding, and precision flags do not apply to custom conversions.
+type ConversionFunc func(buf *bytes.Buffer, t time.Time, opts Options)
+
+var gConversions sync.Map
+
+// RegisterConversion adds %{name} to the conversions understood by Strftime
+// and friends.  Built-in names take precedence.  Registering a nil fn
+// removes the conversion.
+func RegisterConversion(name string, fn ConversionFunc) {
+	if fn == nil {
+		gConversions.Delete(name)
+		return
+	}
+	gConversions.Store(name, fn)
+}
+
+// CompiledPattern is a Strftime pattern that is known to contain only valid
+// conversions.
+type CompiledPattern struct {
+	pattern string
+	opts    Options
+}
+
+// CompilePattern validates pattern, returning a *PatternError describing the
+// first invalid conversion.
+func CompilePattern(pattern string, opts Options) (*CompiledPattern, error) {
+	var buf bytes.Buffer
+	conv := timeConverter{t: validationTime, opts: &opts, dryRun: true}
+	if err := formatPattern(&buf, pattern, conv); err != nil {
+		return nil, err
+	}
+	return &CompiledPattern{pattern: pattern, opts: opts}, nil
+}
+
+func (cp *CompiledPattern) String() string {
+	return cp.pattern
+}
+
+func (cp *CompiledPattern) Format(t time.Time) string {
+	return StrftimeWithOptions(cp.pattern, t, cp.opts)
+}
+
+var validationTime = time.Date(2006, 1, 2, 15, 4, 5, 999999999, time.FixedZone("MST", -7*60*60))
+
+// safeConvert calls conv.Convert, treating a panic as a failed conversion
+// and discarding its partial output, so that a faulty converter, such as a
+// registered ConversionFunc, cannot crash the caller mid-format.
+func safeConvert(conv converter, buf *bytes.Buffer, fs formatState, verb rune, upper bool) (ok bool) {
+	mark := buf.Len()
+	defer func() {
+		if recover() != nil {
+			buf.Truncate(mark)
+			ok = false
+		}
+	}()
+	if zc, isZulu := conv.(zuluConverter); isZulu && upper && verb == 'z' {
+		return zc.ConvertZulu(buf, fs)
+	}
+	return conv.Convert(buf, fs, verb)
+}
+
+// safeConvertNamed is safeConvert for %{name} conversions.
+func safeConvertNamed(conv converter, buf *bytes.Buffer, fs formatState, name string) (ok bool) {
+	mark := buf.Len()
+	defer func() {
+		if recover() != nil {
+			buf.Truncate(mark)
+			ok = false
+		}
+	}()
+	return conv.ConvertNamed(buf, fs, name)
+}
+
+// upperFrom uppercases buf from offset mark onward.
+func upperFrom(buf *bytes.Buffer, mark int) {
+	tail := bytes.ToUpper(buf.Bytes()[mark:])
+	buf.Truncate(mark)
+	buf.Write(tail)
+}
+
+// converter expands the conversions of a pattern for one kind of value.
+// Both methods return false if the verb or name is not recognized.
+type converter interface {
+	Convert(buf *bytes.Buffer, fs formatState, verb rune) bool
+	ConvertNamed(buf *bytes.Buffer, fs formatState, name string) bool
+}
+
+// zuluConverter is implemented by converters that give %#z its own meaning,
+// rather than an uppercased %z.
+type zuluConverter interface {
+	ConvertZulu(buf *bytes.Buffer, fs formatState) bool
+}
+
+// PatternError reports an invalid conversion.  Offset is the byte offset of
+// the '%' that begins it.  Verb is empty if the pattern ended mid-conversion.
+type PatternError struct {
+	Pattern string
+	Offset  int
+	Verb    string
+}
+
+func (err *PatternError) Error() string {
+	if err.Verb == "" {
+		return fmt.Sprintf("unterminated conversion at offset %d in pattern %q", err.Offset, err.Pattern)
+	}
+	return fmt.Sprintf("invalid conversion %q at offset %d in pattern %q", err.Verb, err.Offset, err.Pattern)
+}
+
+// defaultMaxWidth caps the width and precision of a conversion, so that a
+// pattern such as "%999999999A" cannot make a single conversion pad out to
+// gigabytes, and so that long runs of digits cannot overflow.
+const defaultMaxWidth = 4096
+
+// widthLimiter is implemented by converters that take their width and
+// precision limit from Options.
+type widthLimiter interface {
+	widthLimit() (limit uint, strict bool)
+}
+
+// formatPattern expands pattern into buf, writing an error marker for each
+// invalid conversion and returning the first such error.
+func formatPattern(buf *bytes.Buffer, pattern string, conv converter) error {
 	var ps parseState = initState
 	var fs formatState
 	fs.Reset()
+	var name []rune
+	var firstErr error
+	var start int
+
+	// '#' uppercases the output of the conversion it modifies, e.g. %#A
+	// renders "MONDAY", except that %#z renders "Z" for UTC (see
+	// ConvertZulu).  mark is where that output begins.
+	var upper bool
+	var mark int
+
+	// overLimit records that the current conversion's width or precision
+	// was clamped to limit.
+	limit, strict := uint(defaultMaxWidth), false
+	if wl, ok := conv.(widthLimiter); ok {
+		limit, strict = wl.widthLimit()
+	}
+	var overLimit bool
+	clamp := func(n uint, ch rune) uint {
+		digit := uint(ch - '0')
+		if digit > limit || n > (limit-digit)/10 {
+			overLimit = true
+			return limit
+		}
+		return n*10 + digit
+	}
+
+	fail := func(what any) {
+		buf.WriteString(fmt.Sprintf("%%!ERR[%v, %v, %q]", ps, fs, what))
+		if firstErr == nil {
+			verb := fmt.Sprint(what)
+			if ch, ok := what.(rune); ok {
+				verb = string(ch)
+			}
+			firstErr = &PatternError{Pattern: pattern, Offset: start, Verb: verb}
+		}
+	}
 
-	fail := func(ch rune) {
-		buf.WriteString(fmt.Sprintf("%%!ERR[%v, %v, %q]", ps, fs, ch))
+	// tooWide rejects a clamped conversion in strict mode.
+	tooWide := func(what any) bool {
+		if !overLimit || !strict {
+			return false
+		}
+		fail(what)
+		fs.Reset()
+		ps = initState
+		return true
 	}
 
-	for _, ch := range pattern {
+	for index, ch := range pattern {
 		switch {
 		case ps == initState && ch == '%':
+			start = index
+			upper, mark, overLimit = false, buf.Len(), false
 			ps = percentState
 		case ps == initState:
 			buf.WriteRune(ch)
 
+		case ps == braceState && ch == '}':
+			if tooWide("{" + string(name) + "}") {
+				break
+			}
+			if !safeConvertNamed(conv, buf, fs, string(name)) {
+				fail("{" + string(name) + "}")
+			} else if upper {
+				upperFrom(buf, mark)
+			}
+			fs.Reset()
+			ps = initState
+		case ps == braceState:
+			name = append(name, ch)
+
+		// A '0' before the width is always the zero-pad flag, however many
+		// times it repeats, so the width begins at the first nonzero digit:
+		// %00A is %0A, which has no width and so is not padded, and %05A is
+		// zero-padded to 5.
 		case ps == percentState && ch == '0':
 			fs.Pad = '0'
 		case ps == percentState && ch == '+':
@@ -200,20 +497,23 @@ func Strftime(pattern string, t time.Time) string {
 			fs.JustifyLeft = true
 		case ps == percentState && ch == '>':
 			fs.JustifyLeft = false
+		case ps == percentState && ch == '#':
+			upper = true
+
 		case ps == percentState && ch >= '1' && ch <= '9':
-			fs.Width = uint(ch - '0')
+			fs.Width = clamp(0, ch)
 			fs.HasWidth = true
 			ps = widthState
 		case ps == percentState && ch == '.':
 			ps = dotState
 
 		case ps == widthState && ch >= '0' && ch <= '9':
-			fs.Width = fs.Width*10 + uint(ch-'0')
+			fs.Width = clamp(fs.Width, ch)
 		case ps == widthState && ch == '.':
 			ps = dotState
 
 		case ps == dotState && ch >= '0' && ch <= '9':
-			fs.Prec = uint(ch - '0')
+			fs.Prec = clamp(0, ch)
 			fs.HasPrec = true
 			ps = precState
 		case ps == dotState:
@@ -221,233 +521,475 @@ func Strftime(pattern string, t time.Time) string {
 			ps = initState
 
 		case ps == precState && ch >= '0' && ch <= '9':
-			fs.Prec = fs.Prec*10 + uint(ch-'0')
+			fs.Prec = clamp(fs.Prec, ch)
+
+		case ch == ':':
+			fs.Colons++
+
+		case ch == '{':
+			name = name[:0]
+			ps = braceState
 
-		case ch == 'A':
-			fs.FormatString(buf, t.Format("Monday"))
+		case ch == '%':
+			if tooWide(ch) {
+				break
+			}
+			fs.FormatString(buf, "%")
 			fs.Reset()
 			ps = initState
 
-		case ch == 'B':
-			fs.FormatString(buf, t.Format("January"))
+		case ch == 'n':
+			if tooWide(ch) {
+				break
+			}
+			fs.FormatString(buf, "\n")
 			fs.Reset()
 			ps = initState
 
-		case ch == 'C':
-			x := parseUint(t.Format("2006"))
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, x/100)
+		case ch == 't':
+			if tooWide(ch) {
+				break
+			}
+			fs.FormatString(buf, "\t")
 			fs.Reset()
 			ps = initState
 
-		case ch == 'D':
-			fs.FormatString(buf, t.Format("01/02/06"))
+		default:
+			if tooWide(ch) {
+				break
+			}
+			if !safeConvert(conv, buf, fs, ch, upper) {
+				fail(ch)
+			} else if upper {
+				upperFrom(buf, mark)
+			}
 			fs.Reset()
 			ps = initState
+		}
+	}
 
-		// 'E': era modifier
+	// An unterminated conversion, such as a trailing "%", is copied
+	// literally, but CompilePattern still rejects it.
+	if ps != initState {
+		buf.WriteString(pattern[start:])
+		if firstErr == nil {
+			firstErr = &PatternError{Pattern: pattern, Offset: start}
+		}
+	}
+	return firstErr
+}
 
-		case ch == 'F':
-			fs.FormatString(buf, t.Format("2006-01-02"))
-			fs.Reset()
-			ps = initState
+type timeConverter struct {
+	t    time.Time
+	opts *Options
 
-		// 'G': ISO year-of-week
+	// dryRun suppresses side effects, such as advancing counters.
+	dryRun bool
 
-		case ch == 'H':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("15")))
-			fs.Reset()
-			ps = initState
+	// peek renders the value each counter would take next, without
+	// advancing it.
+	peek bool
+}
 
-		case ch == 'I':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("03")))
-			fs.Reset()
-			ps = initState
+func (c timeConverter) Convert(buf *bytes.Buffer, fs formatState, verb rune) bool {
+	if fs.Colons != 0 && verb != 'z' {
+		return false
+	}
 
-		case ch == 'M':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("04")))
-			fs.Reset()
-			ps = initState
+	t := c.t
+	switch verb {
+	case 'A':
+		c.formatName(buf, fs, t.Format("Monday"), c.opts.Locale.nameWidth(true))
 
-		// 'O': alternative digit modifier
+	case 'B':
+		c.formatName(buf, fs, c.opts.Locale.LongMonth(t.Month()), c.opts.Locale.nameWidth(true))
 
-		case ch == 'P':
-			fs.FormatString(buf, t.Format("pm"))
-			fs.Reset()
-			ps = initState
+	case 'C':
+		fs.SetDefaultWidth(2)
+		fs.FormatInt(buf, floorDiv(int64(t.Year()), 100))
 
-		case ch == 'R':
-			fs.FormatString(buf, t.Format("15:04"))
-			fs.Reset()
-			ps = initState
+	case 'D':
+		fs.FormatString(buf, t.Format("01/02/06"))
 
-		case ch == 'S':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("05")))
-			fs.Reset()
-			ps = initState
+	// 'E': era modifier
 
-		case ch == 'T':
-			fs.FormatString(buf, t.Format("15:04:05"))
-			fs.Reset()
-			ps = initState
+	case 'F':
+		fs.FormatString(buf, t.Format("2006-01-02"))
 
-		// 'U': week number, 00-53, 1st Sun is week 01
+	case 'G':
+		year, _ := t.ISOWeek()
+		fs.SetDefaultWidth(4)
+		fs.FormatInt(buf, int64(year))
 
-		// 'V': ISO week number
+	case 'H':
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, t.Hour())
 
-		// 'W': week number, 00-53, 1st Mon is week 01
+	case 'I':
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, hour12(t))
 
-		case ch == 'X':
-			fs.FormatString(buf, t.Format("15:04:05"))
-			fs.Reset()
-			ps = initState
+	case 'M':
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, t.Minute())
 
-		case ch == 'Y':
-			fs.SetDefaultWidth(4)
-			fs.FormatUint(buf, parseUint(t.Format("2006")))
-			fs.Reset()
-			ps = initState
+	// 'O': alternative digit modifier
 
-		case ch == 'Z':
-			fs.FormatString(buf, t.Format("MST"))
-			fs.Reset()
-			ps = initState
+	case 'P':
+		fs.FormatString(buf, t.Format("pm"))
 
-		case ch == 'a':
-			fs.FormatString(buf, t.Format("Mon"))
-			fs.Reset()
-			ps = initState
+	case 'R':
+		fs.FormatString(buf, t.Format("15:04"))
 
-		case ch == 'b':
-			fs.FormatString(buf, t.Format("Jan"))
-			fs.Reset()
-			ps = initState
+	case 'S':
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, t.Second())
 
-		case ch == 'c':
-			fs.FormatString(buf, t.Format("Mon Jan _2 15:04:05 2006"))
-			fs.Reset()
-			ps = initState
+	case 'T':
+		fs.FormatString(buf, t.Format("15:04:05"))
 
-		case ch == 'd':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("02")))
-			fs.Reset()
-			ps = initState
+	// 'U': week number, 00-53, 1st Sun is week 01
 
-		case ch == 'e':
-			fs.SetDefaultPad(' ')
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("02")))
-			fs.Reset()
-			ps = initState
+	case 'V':
+		_, week := t.ISOWeek()
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, week)
 
-		// 'g': ISO week-based year, 2 digits
+	// 'W': week number, 00-53, 1st Mon is week 01
 
-		case ch == 'h':
-			fs.FormatString(buf, t.Format("Jan"))
-			fs.Reset()
-			ps = initState
+	case 'X':
+		fs.FormatString(buf, t.Format(c.opts.Locale.Layout('X')))
 
-		// 'j': Julian day of year
+	case 'Y':
+		fs.SetDefaultWidth(4)
+		fs.FormatInt(buf, int64(t.Year()))
 
-		case ch == 'k':
-			fs.SetDefaultPad(' ')
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("15")))
-			fs.Reset()
-			ps = initState
+	case 'Z':
+		// Zones without a real abbreviation, including tzdata's numeric
+		// ones like "+03", render as %z does.
+		name, offset := t.Zone()
+		if isNumericZone(name) {
+			name = formatOffset(offset, 1)
+			name = name[:3] + name[4:]
+		}
+		fs.FormatString(buf, name)
 
-		case ch == 'l':
-			fs.SetDefaultPad(' ')
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("03")))
-			fs.Reset()
-			ps = initState
+	case 'a':
+		c.formatName(buf, fs, t.Format("Mon"), c.opts.Locale.nameWidth(false))
 
-		case ch == 'm':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("01")))
-			fs.Reset()
-			ps = initState
+	case 'b', 'h':
+		c.formatName(buf, fs, c.opts.Locale.ShortMonth(t.Month()), c.opts.Locale.nameWidth(false))
 
-		case ch == 'n':
-			fs.FormatString(buf, "\n")
-			fs.Reset()
-			ps = initState
+	case 'c':
+		fs.FormatString(buf, t.Format(c.opts.Locale.Layout('c')))
 
-		case ch == 'p':
-			fs.FormatString(buf, t.Format("PM"))
-			fs.Reset()
-			ps = initState
+	case 'd':
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, t.Day())
 
-		case ch == 'r':
-			fs.FormatString(buf, t.Format("03:04:05 PM"))
-			fs.Reset()
-			ps = initState
+	case 'e':
+		fs.SetDefaultPad(' ')
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, t.Day())
 
-		case ch == 's':
-			s := t.Unix()
-			fs.FormatUint(buf, uint64(s))
-			fs.Reset()
-			ps = initState
+	case 'g':
+		year, _ := t.ISOWeek()
+		year %= 100
+		if year < 0 {
+			year += 100
+		}
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, year)
 
-		case ch == 't':
-			fs.FormatString(buf, "\t")
-			fs.Reset()
-			ps = initState
+	// 'j': Julian day of year
 
-		// 'u': numeric day of week (Mon=1 Sun=7)
+	case 'k':
+		fs.SetDefaultPad(' ')
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, t.Hour())
 
-		// 'w': numeric day of week (Sun=0 Sat=6)
+	case 'l':
+		fs.SetDefaultPad(' ')
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, hour12(t))
 
-		case ch == 'x':
-			fs.FormatString(buf, t.Format("2006-01-02"))
-			fs.Reset()
-			ps = initState
+	case 'm':
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, int(t.Month()))
 
-		case ch == 'y':
-			fs.SetDefaultWidth(2)
-			fs.FormatUint(buf, parseUint(t.Format("06")))
-			fs.Reset()
-			ps = initState
+	case 'p':
+		fs.FormatString(buf, t.Format("PM"))
 
-		case ch == 'z':
-			fs.SetDefaultWidth(5)
-			fs.FormatInt(buf, parseInt(t.Format("-0700")))
-			fs.Reset()
-			ps = initState
+	case 'r':
+		fs.FormatString(buf, t.Format("03:04:05 PM"))
 
-		case ch == '%':
-			fs.FormatString(buf, "%")
-			fs.Reset()
-			ps = initState
+	case 's':
+		// Precision selects the unit: .3 for milliseconds, .6 for
+		// microseconds, .9 for nanoseconds.
+		var s int64
+		switch {
+		case !fs.HasPrec || fs.Prec == 0:
+			s = t.Unix()
+		case fs.Prec == 3:
+			s = t.UnixMilli()
+		case fs.Prec == 6:
+			s = t.UnixMicro()
+		case fs.Prec == 9:
+			s = t.UnixNano()
+		default:
+			return false
+		}
+		fs.FormatInt(buf, s)
+
+	// 'u': numeric day of week (Mon=1 Sun=7)
 
+	// 'w': numeric day of week (Sun=0 Sat=6)
+
+	case 'x':
+		fs.FormatString(buf, t.Format(c.opts.Locale.Layout('x')))
+
+	case 'y':
+		fs.SetDefaultWidth(2)
+		y := t.Year() % 100
+		if y < 0 {
+			y += 100
+		}
+		fs.formatSmall(buf, y)
+
+	case 'z':
+		if fs.Colons > 3 {
+			return false
+		}
+		if fs.Colons != 0 {
+			_, offset := t.Zone()
+			fs.FormatString(buf, formatOffset(offset, fs.Colons))
+			break
+		}
+		_, offset := t.Zone()
+		neg := offset < 0
+		if neg {
+			offset = -offset
+		}
+		if !neg && (fs.Pad == 0 || fs.Pad == '0') {
+			fs.Pad = '+'
+		}
+		// Offsets with seconds, such as the LMT of many historical zones,
+		// render as +hhmmss rather than being silently truncated.
+		hhmm := uint64((offset/3600)*100 + (offset/60)%60)
+		if s := offset % 60; s != 0 {
+			fs.SetDefaultWidth(7)
+			fs.formatIntInternal(buf, neg, hhmm*100+uint64(s))
+			break
+		}
+		fs.SetDefaultWidth(5)
+		fs.formatIntInternal(buf, neg, hhmm)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// widthLimit returns the width and precision cap from Options, and whether
+// exceeding it is an error.
+func (c timeConverter) widthLimit() (uint, bool) {
+	if c.opts.MaxWidth == 0 {
+		return defaultMaxWidth, c.opts.StrictWidth
+	}
+	return c.opts.MaxWidth, c.opts.StrictWidth
+}
+
+// ConvertZulu renders %#z: "Z" for a zero offset, as in RFC 3339 and Go's
+// "Z07:00", and otherwise the offset in the %:z style, or the %::z or %:::z
+// style if more colons are given.
+func (c timeConverter) ConvertZulu(buf *bytes.Buffer, fs formatState) bool {
+	if fs.Colons > 3 {
+		return false
+	}
+	_, offset := c.t.Zone()
+	if offset == 0 {
+		fs.FormatString(buf, "Z")
+		return true
+	}
+	fs.FormatString(buf, formatOffset(offset, max(fs.Colons, 1)))
+	return true
+}
+
+// formatName writes a day or month name, fitted to the locale's column
+// width when the pattern does not specify one.
+func (c timeConverter) formatName(buf *bytes.Buffer, fs formatState, name string, width uint) {
+	if width != 0 && !fs.HasWidth {
+		fs.SetDefaultWidth(width)
+		fs.SetDefaultPrec(width)
+		fs.JustifyLeft = true
+	}
+	fs.FormatString(buf, name)
+}
+
+func (c timeConverter) ConvertNamed(buf *bytes.Buffer, fs formatState, name string) bool {
+	switch {
+	case name == "ago":
+		fs.FormatString(buf, c.opts.Locale.Relative(c.t, c.opts.ref()))
+	case name == "fy":
+		year, _, ok := c.opts.fiscal(c.t)
+		if !ok {
+			return false
+		}
+		fs.SetDefaultWidth(4)
+		fs.FormatInt(buf, int64(year))
+	case name == "fq":
+		_, quarter, ok := c.opts.fiscal(c.t)
+		if !ok {
+			return false
+		}
+		fs.FormatUint(buf, uint64(quarter))
+	case name == "wom":
+		fs.FormatUint(buf, uint64(WeekOfMonth(c.t)))
+	case name == "week":
+		if c.opts.WeekStart < time.Sunday || c.opts.WeekStart > time.Saturday {
+			return false
+		}
+		fs.SetDefaultWidth(2)
+		fs.formatSmall(buf, WeekOfYear(c.t, c.opts.WeekStart))
+	case name == "epochday":
+		// Days since 1970-01-01 UTC; the same instant yields the same
+		// day number regardless of c.t's zone.
+		fs.FormatInt(buf, floorDiv(c.t.Unix(), 86400))
+	case strings.HasPrefix(name, "counter:"):
+		var value uint64
+		switch {
+		case c.dryRun:
+		case c.peek:
+			value = peekCounter(name[8:])
 		default:
-			fail(ch)
-			ps = initState
+			value = nextCounter(name[8:])
+		}
+		fs.FormatUint(buf, value)
+	case isTimeFormatAlias(name):
+		// The LOG_TIMEFORMAT aliases, such as %{rfc3339} or
+		// %{kitchen.ms}, double as composite conversions.
+		layout := ExpandTimeFormat(name)
+		if isUnixTimeFormat(layout) {
+			fs.FormatString(buf, formatUnixTime(c.t, layout))
+			break
+		}
+		fs.FormatString(buf, c.t.Format(layout))
+	default:
+		fn, found := gConversions.Load(name)
+		if !found {
+			return false
+		}
+		if !c.dryRun {
+			fn.(ConversionFunc)(buf, c.t, *c.opts)
 		}
 	}
-	return buf.String()
+	return true
+}
+
+// WeekOfMonth returns the week of the month containing t, from 1 to 6, with
+// weeks starting on Sunday: week 1 runs from the 1st through the first
+// Saturday.  It backs %{wom}.
+func WeekOfMonth(t time.Time) int {
+	first := (int(t.Weekday()) - (t.Day()-1)%7 + 7) % 7
+	return (t.Day()+first-1)/7 + 1
+}
+
+// WeekOfYear returns the week of the year containing t, from 0 to 53, with
+// weeks starting on start: week 1 begins on the year's first such day, and
+// any days before it fall in week 0.  It backs %{week}.
+func WeekOfYear(t time.Time, start time.Weekday) int {
+	offset := (int(t.Weekday()) - int(start) + 7) % 7
+	return (t.YearDay() - 1 + 7 - offset) / 7
 }
 
-func parseInt(str string) int64 {
-	i64, err := strconv.ParseInt(str, 10, 0)
-	if err != nil {
-		panic(fmt.Errorf("strconv.ParseInt: %q: %w", str, err))
+// truncateRunes returns the first n runes of str.
+func truncateRunes(str string, n uint) string {
+	for i := range str {
+		if n == 0 {
+			return str[:i]
+		}
+		n--
+	}
+	return str
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// gCounters backs %{counter:<name>}.  Counters live in process memory only:
+// they start over at 1 in each new process and are not coordinated with
+// other processes writing to the same directory.
+var gCounters sync.Map
+
+func nextCounter(name string) uint64 {
+	value, found := gCounters.Load(name)
+	if !found {
+		value, _ = gCounters.LoadOrStore(name, new(atomic.Uint64))
+	}
+	return value.(*atomic.Uint64).Add(1)
+}
+
+// peekCounter returns the value nextCounter would return next.
+func peekCounter(name string) uint64 {
+	if value, found := gCounters.Load(name); found {
+		return value.(*atomic.Uint64).Load() + 1
+	}
+	return 1
+}
+
+// formatOffset renders a UTC offset in seconds in the GNU colon styles:
+// 1 colon is +hh:mm, 2 colons is +hh:mm:ss, and 3 colons uses only as many
+// components as needed (+hh, +hh:mm, or +hh:mm:ss).  A zero offset is "+00".
+func formatOffset(offset int, colons uint) string {
+	sign := byte('+')
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	h, m, s := offset/3600, (offset/60)%60, offset%60
+
+	n := 3
+	switch {
+	case colons == 1:
+		n = 2
+	case colons == 3 && s == 0 && m == 0:
+		n = 1
+	case colons == 3 && s == 0:
+		n = 2
+	}
+
+	b := make([]byte, 0, 9)
+	b = append(b, sign)
+	for i, x := range []int{h, m, s}[:n] {
+		if i > 0 {
+			b = append(b, ':')
+		}
+		b = append(b, byte('0'+x/10), byte('0'+x%10))
+	}
+	return string(b)
+}
+
+func isNumericZone(name string) bool {
+	for _, ch := range name {
+		if (ch < '0' || ch > '9') && ch != '+' && ch != '-' {
+			return false
+		}
 	}
-	return i64
+	return true
 }
 
-func parseUint(str string) uint64 {
-	u64, err := strconv.ParseUint(str, 10, 0)
-	if err != nil {
-		panic(fmt.Errorf("strconv.ParseUint: %q: %w", str, err))
+// hour12 returns t's hour on a 12-hour clock, 1-12.
+func hour12(t time.Time) int {
+	h := t.Hour() % 12
+	if h == 0 {
+		h = 12
 	}
-	return u64
+	return h
 }
 
 func trimLeadingZeroes(str string) (rune, string) {
@@ -1,7 +1,10 @@
 package autolog
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,3 +47,1142 @@ func TestStrftime(t *testing.T) {
 		})
 	}
 }
+
+func TestStrftimeWithOptions_Ago(t *testing.T) {
+	type testCase struct {
+		Delta  time.Duration
+		Expect string
+	}
+
+	ref := time.Unix(1696952439, 0).UTC()
+
+	testData := [...]testCase{
+		{0, "just now"},
+		{-2 * time.Second, "just now"},
+		{-1 * time.Second, "just now"},
+		{-30 * time.Second, "30 seconds ago"},
+		{-1 * time.Minute, "1 minute ago"},
+		{-5*time.Minute - 20*time.Second, "5 minutes ago"},
+		{-1 * time.Hour, "1 hour ago"},
+		{-2*time.Hour - 59*time.Minute, "2 hours ago"},
+		{-3 * 24 * time.Hour, "3 days ago"},
+		{10 * time.Minute, "in 10 minutes"},
+		{1 * time.Hour, "in 1 hour"},
+	}
+
+	for _, row := range testData {
+		name := fmt.Sprintf("[%v]", row.Delta)
+		t.Run(name, func(t *testing.T) {
+			actual := StrftimeWithOptions("%{ago}", ref.Add(row.Delta), Options{Ref: ref})
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestStrftimeWithOptions_AgoLocale(t *testing.T) {
+	ref := time.Unix(1696952439, 0).UTC()
+	loc := &Locale{
+		JustNow: "à l'instant",
+		Ago:     "il y a %s",
+		In:      "dans %s",
+		Minute:  UnitNames{"minute", "minutes"},
+		Hour:    UnitNames{"heure", "heures"},
+	}
+
+	testData := [...]struct {
+		Delta  time.Duration
+		Expect string
+	}{
+		{0, "à l'instant"},
+		{-2 * time.Hour, "il y a 2 heures"},
+		{5 * time.Minute, "dans 5 minutes"},
+		{-3 * 24 * time.Hour, "il y a 3 days"},
+	}
+
+	for _, row := range testData {
+		actual := StrftimeWithOptions("%{ago}", ref.Add(row.Delta), Options{Ref: ref, Locale: loc})
+		if actual != row.Expect {
+			t.Errorf("%v: wrong result:\n\texpect: %q\n\tactual: %q", row.Delta, row.Expect, actual)
+		}
+	}
+
+	actual := Strftime("%{bogus}", ref)
+	if !strings.HasPrefix(actual, "%!ERR[") {
+		t.Errorf("expected error marker for unknown directive, got %q", actual)
+	}
+}
+
+func TestStrftime_Counter(t *testing.T) {
+	t0 := time.Unix(1136239445, 0).UTC()
+
+	first := Strftime("app-%{counter:TestStrftime_Counter}.log", t0)
+	second := Strftime("app-%{counter:TestStrftime_Counter}.log", t0)
+	if first != "app-1.log" || second != "app-2.log" {
+		t.Errorf("expected consecutive values, got %q and %q", first, second)
+	}
+
+	other := Strftime("%{counter:TestStrftime_Counter.other}", t0)
+	if other != "1" {
+		t.Errorf("expected independent counter to start at 1, got %q", other)
+	}
+
+	padded := Strftime("%04{counter:TestStrftime_Counter}|%-4{counter:TestStrftime_Counter}|", t0)
+	if padded != "0003|4   |" {
+		t.Errorf("wrong padding: %q", padded)
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Verb    string
+		Offset  int
+	}
+
+	testData := [...]testCase{
+		{"app-%Y%m%d.log", "", 0},
+		{"app-%{counter:TestCompilePattern}.log", "", 0},
+		{"app-%Q.log", "Q", 4},
+		{"%Y-%.x", "x", 3},
+		{"é%{nope}", "{nope}", 2},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Pattern, func(t *testing.T) {
+			cp, err := CompilePattern(row.Pattern, Options{})
+			if row.Verb == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if cp.String() != row.Pattern {
+					t.Errorf("String: expected %q, got %q", row.Pattern, cp.String())
+				}
+				return
+			}
+
+			var perr *PatternError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected *PatternError, got %v", err)
+			}
+			if perr.Verb != row.Verb || perr.Offset != row.Offset {
+				t.Errorf("expected verb %q at %d, got %q at %d", row.Verb, row.Offset, perr.Verb, perr.Offset)
+			}
+		})
+	}
+
+	// Validation must not consume counter values.
+	if actual := Strftime("%{counter:TestCompilePattern}", time.Now()); actual != "1" {
+		t.Errorf("counter advanced during validation: got %q", actual)
+	}
+}
+
+func TestStrftime_ColonOffsets(t *testing.T) {
+	type testCase struct {
+		Zone    *time.Location
+		Pattern string
+		Expect  string
+	}
+
+	utc := time.UTC
+	mst := time.FixedZone("MST", -7*60*60)
+	ist := time.FixedZone("IST", 5*60*60+30*60)
+	lmt := time.FixedZone("LMT", 9*60+21) // Paris mean time, +00:09:21
+	nst := time.FixedZone("NST", -(3*60*60 + 30*60))
+
+	instant := time.Unix(1136239445, 0)
+
+	testData := [...]testCase{
+		{utc, "%z|%:z|%::z|%:::z", "+0000|+00:00|+00:00:00|+00"},
+		{mst, "%z|%:z|%::z|%:::z", "-0700|-07:00|-07:00:00|-07"},
+		{ist, "%z|%:z|%::z|%:::z", "+0530|+05:30|+05:30:00|+05:30"},
+		{nst, "%:z|%:::z", "-03:30|-03:30"},
+		{lmt, "%z|%:z|%::z|%:::z", "+000921|+00:09|+00:09:21|+00:09:21"},
+		{time.FixedZone("LMT", -(4*60*60 + 56*60 + 2)), "%z", "-045602"},
+		{utc, "%10:z", "    +00:00"},
+		{utc, "%#z|%#:z|%#::z|%#:::z", "Z|Z|Z|Z"},
+		{ist, "%#z|%#:z|%#::z|%#:::z", "+05:30|+05:30|+05:30:00|+05:30"},
+		{mst, "%#z|%#:::z", "-07:00|-07"},
+		{lmt, "%#z|%#::z", "+00:09|+00:09:21"},
+		{utc, "[%#3z]", "[  Z]"},
+		{utc, "%#::::z", "%!ERR[percentState, {0 0 0 false false false 4}, 'z']"},
+		{utc, "%::::z", "%!ERR[percentState, {0 0 0 false false false 4}, 'z']"},
+		{utc, "%:H", "%!ERR[percentState, {0 0 0 false false false 1}, 'H']"},
+	}
+
+	for _, row := range testData {
+		name := fmt.Sprintf("[%s][%s]", row.Zone, row.Pattern)
+		t.Run(name, func(t *testing.T) {
+			actual := Strftime(row.Pattern, instant.In(row.Zone))
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestStrftimeWithOptions_Fiscal(t *testing.T) {
+	type testCase struct {
+		Time   time.Time
+		Start  time.Month
+		Expect string
+	}
+
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	testData := [...]testCase{
+		{date(2024, time.March, 31), time.April, "FY2023 Q4"},
+		{date(2024, time.April, 1), time.April, "FY2024 Q1"},
+		{date(2024, time.June, 30), time.April, "FY2024 Q1"},
+		{date(2024, time.July, 1), time.April, "FY2024 Q2"},
+		{date(2024, time.December, 31), time.April, "FY2024 Q3"},
+		{date(2025, time.January, 1), time.April, "FY2024 Q4"},
+		{date(2024, time.March, 31), 0, "FY2024 Q1"},
+		{date(2024, time.October, 1), time.October, "FY2024 Q1"},
+		{date(2024, time.September, 30), time.October, "FY2023 Q4"},
+	}
+
+	for _, row := range testData {
+		name := fmt.Sprintf("[%s][%v]", row.Time.Format("2006-01-02"), row.Start)
+		t.Run(name, func(t *testing.T) {
+			opts := Options{FiscalYearStartMonth: row.Start}
+			actual := StrftimeWithOptions("FY%{fy} Q%{fq}", row.Time, opts)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+
+	for _, bad := range []time.Month{-1, 13} {
+		_, err := CompilePattern("%{fy}", Options{FiscalYearStartMonth: bad})
+		if err == nil {
+			t.Errorf("start month %d: expected error, got nil", bad)
+		}
+	}
+}
+
+func TestStrftimeWithOptions_MonthAlias(t *testing.T) {
+	french := &Locale{
+		ShortMonths: [12]string{
+			"janv.", "févr.", "mars", "avr.", "mai", "juin",
+			"juil.", "août", "sept.", "oct.", "nov.", "déc.",
+		},
+		LongMonths: [12]string{
+			"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre", "décembre",
+		},
+	}
+
+	for _, loc := range []*Locale{nil, &EnglishLocale, french} {
+		for m := time.January; m <= time.December; m++ {
+			tm := time.Date(2024, m, 15, 0, 0, 0, 0, time.UTC)
+			opts := Options{Locale: loc}
+			b := StrftimeWithOptions("%b", tm, opts)
+			h := StrftimeWithOptions("%h", tm, opts)
+			if b != h {
+				t.Errorf("%v: %%b=%q but %%h=%q", m, b, h)
+			}
+			if expect := loc.ShortMonth(m); b != expect {
+				t.Errorf("%v: expected %q, got %q", m, expect, b)
+			}
+			if actual, expect := StrftimeWithOptions("%B", tm, opts), loc.LongMonth(m); actual != expect {
+				t.Errorf("%v: %%B: expected %q, got %q", m, expect, actual)
+			}
+		}
+	}
+
+	tm := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	if actual := StrftimeWithOptions("%b|%h|%B", tm, Options{Locale: french}); actual != "févr.|févr.|février" {
+		t.Errorf("french: got %q", actual)
+	}
+	if actual := Strftime("%b|%h|%B", tm); actual != "Feb|Feb|February" {
+		t.Errorf("default: got %q", actual)
+	}
+}
+
+func TestStrftime_EpochDay(t *testing.T) {
+	type testCase struct {
+		Time    time.Time
+		Pattern string
+		Expect  string
+	}
+
+	mst := time.FixedZone("MST", -7*60*60)
+	t0 := time.Unix(1136239445, 999999999).In(mst) // 2006-01-02T15:04:05.999999999-0700
+
+	testData := [...]testCase{
+		{time.Unix(0, 0).UTC(), "%{epochday}", "0"},
+		{time.Unix(86400, 0).UTC(), "%{epochday}", "1"},
+		{time.Unix(86399, 0).In(mst), "%{epochday}", "0"},
+		{time.Unix(-1, 0).UTC(), "%{epochday}", "-1"},
+		{t0, "%{epochday}", "13150"},
+		{t0.UTC(), "%{epochday}", "13150"},
+		{time.Unix(86400, 0).UTC(), "%05{epochday}", "00001"},
+	}
+
+	for _, row := range testData {
+		name := fmt.Sprintf("[%s][%s]", row.Time.Format(time.RFC3339), row.Pattern)
+		t.Run(name, func(t *testing.T) {
+			actual := Strftime(row.Pattern, row.Time)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestStrftime_UnixPrecision(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Expect  string
+	}
+
+	instant := time.Unix(1696952439, 111222333).UTC()
+
+	testData := [...]testCase{
+		{"%s", "1696952439"},
+		{"%.0s", "1696952439"},
+		{"%.3s", "1696952439111"},
+		{"%.6s", "1696952439111222"},
+		{"%.9s", "1696952439111222333"},
+		{"%15.3s", "001696952439111"},
+		{"%_15.3s", "__1696952439111"},
+		{"%-15.3s|", "1696952439111  |"},
+		{"%.4s", "%!ERR[precState, {0 4 0 false true false 0}, 's']"},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Pattern, func(t *testing.T) {
+			actual := Strftime(row.Pattern, instant)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestStrftime_UnixNegative(t *testing.T) {
+	type testCase struct {
+		Time    time.Time
+		Pattern string
+		Expect  string
+	}
+
+	t1960 := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testData := [...]testCase{
+		{t1960, "%s", "-315619200"},
+		{t1960, "%.3s", "-315619200000"},
+		{t1960, "%12s", "-00315619200"},
+		{time.Unix(-1, 0), "%s", "-1"},
+		{time.Unix(0, 0), "%s", "0"},
+	}
+
+	for _, row := range testData {
+		name := fmt.Sprintf("[%s][%s]", row.Time.Format(time.RFC3339), row.Pattern)
+		t.Run(name, func(t *testing.T) {
+			actual := Strftime(row.Pattern, row.Time)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestStrftimeWithOptions_MonthNames(t *testing.T) {
+	upper := &Locale{
+		ShortMonths: [12]string{
+			"JAN", "FEB", "MAR", "APR", "MAY", "JUN",
+			"JUL", "AUG", "SEP", "OCT", "NOV", "DEC",
+		},
+		LongMonths: [12]string{
+			"JANUARY", "FEBRUARY", "MARCH", "APRIL", "MAY", "JUNE",
+			"JULY", "AUGUST", "SEPTEMBER", "OCTOBER", "NOVEMBER", "DECEMBER",
+		},
+	}
+	french := &Locale{
+		LongMonths: [12]string{
+			"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre", "décembre",
+		},
+	}
+
+	type testCase struct {
+		Locale  *Locale
+		Month   time.Month
+		Pattern string
+		Expect  string
+	}
+
+	testData := [...]testCase{
+		{upper, time.October, "%b|%h|%B", "OCT|OCT|OCTOBER"},
+		{upper, time.March, "%.3B", "MAR"},
+		{french, time.February, "%.3B", "fév"},
+		{french, time.December, "%.2B", "dé"},
+		{french, time.August, "[%6B]", "[  août]"},
+		{french, time.August, "[%-6B]", "[août  ]"},
+		{french, time.February, "[%-8.4B]", "[févr    ]"},
+		{french, time.February, "%b", "Feb"}, // unset short names fall back
+	}
+
+	for _, row := range testData {
+		tm := time.Date(2024, row.Month, 15, 0, 0, 0, 0, time.UTC)
+		actual := StrftimeWithOptions(row.Pattern, tm, Options{Locale: row.Locale})
+		if actual != row.Expect {
+			t.Errorf("%v %q: expected %q, got %q", row.Month, row.Pattern, row.Expect, actual)
+		}
+	}
+
+	// A locale column width truncates by runes as well.
+	narrow := *french
+	narrow.LongNameWidth = 4
+	tm := time.Date(2024, time.December, 15, 0, 0, 0, 0, time.UTC)
+	if actual := StrftimeWithOptions("[%B]", tm, Options{Locale: &narrow}); actual != "[déce]" {
+		t.Errorf("LongNameWidth: expected %q, got %q", "[déce]", actual)
+	}
+}
+
+func TestStrftimeWithOptions_LocaleLayouts(t *testing.T) {
+	german := &Locale{
+		DateTimeLayout: "Mon 02.01.2006 15:04:05",
+		DateLayout:     "02.01.2006",
+	}
+
+	tm := time.Date(2023, time.October, 10, 8, 40, 39, 0, time.UTC)
+
+	if actual, expect := Strftime("%c|%x|%X", tm), "Tue Oct 10 08:40:39 2023|2023-10-10|08:40:39"; actual != expect {
+		t.Errorf("default:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+
+	// TimeLayout is unset, so %X falls back to English.
+	actual := StrftimeWithOptions("%c|%x|%X", tm, Options{Locale: german})
+	if expect := "Tue 10.10.2023 08:40:39|10.10.2023|08:40:39"; actual != expect {
+		t.Errorf("german:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+}
+
+func TestStrftimeWithOptions_NameWidth(t *testing.T) {
+	loc := &Locale{
+		ShortMonths: [12]string{
+			"jan", "feb", "maart", "apr", "mei", "juni",
+			"juli", "aug", "sept", "okt", "nov", "dec",
+		},
+		ShortNameWidth: 4,
+		LongNameWidth:  9,
+	}
+	opts := Options{Locale: loc}
+
+	for m := time.January; m <= time.December; m++ {
+		tm := time.Date(2024, m, 15, 0, 0, 0, 0, time.UTC)
+		if actual := StrftimeWithOptions("%b|", tm, opts); len(actual) != 5 {
+			t.Errorf("%v: %%b: expected 4 columns, got %q", m, actual)
+		}
+		if actual := StrftimeWithOptions("%A|", tm, opts); len(actual) != 10 {
+			t.Errorf("%v: %%A: expected 9 columns, got %q", m, actual)
+		}
+	}
+
+	type testCase struct {
+		Pattern string
+		Expect  string
+	}
+
+	tm := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	testData := [...]testCase{
+		{"%b|%a|%A|%B", "maar|Mon |Monday   |March    "},
+		{"%6b|%2a|%.2A", " maart|Mon|Mo       "},
+	}
+	for _, row := range testData {
+		if actual := StrftimeWithOptions(row.Pattern, tm, opts); actual != row.Expect {
+			t.Errorf("%q: expected %q, got %q", row.Pattern, row.Expect, actual)
+		}
+	}
+
+	if actual := Strftime("%b|%a|%A", tm); actual != "Mar|Mon|Monday" {
+		t.Errorf("default: got %q", actual)
+	}
+}
+
+func TestStrftime_UpperFlag(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Expect  string
+	}
+
+	z0 := time.FixedZone("MST", -7*60*60)
+	tm := time.Date(2006, time.January, 2, 15, 4, 5, 0, z0)
+
+	testData := [...]testCase{
+		{"%#A", "MONDAY"},
+		{"%#a", "MON"},
+		{"%#B", "JANUARY"},
+		{"%#b|%#h", "JAN|JAN"},
+		{"%#p|%#P", "PM|PM"},
+		{"%#Z", "MST"},
+		{"%#.3A", "MON"},
+		{"%#8A|", "  MONDAY|"},
+		{"%-#8.2B|", "JA      |"},
+		{"%#H:%#M %#Y", "15:04 2006"},
+	}
+	for _, row := range testData {
+		if actual := Strftime(row.Pattern, tm); actual != row.Expect {
+			t.Errorf("%q: expected %q, got %q", row.Pattern, row.Expect, actual)
+		}
+	}
+}
+
+func TestStrftime_SpacePadOverride(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Expect  string
+	}
+
+	tm := time.Date(2006, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	testData := [...]testCase{
+		{"%l", " 3"},
+		{"%k", " 3"},
+		{"%e", " 2"},
+		{"%0l", "03"},
+		{"%0k", "03"},
+		{"%0e", "02"},
+		{"%03l", "003"},
+		{"%3k", "  3"},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Pattern, func(t *testing.T) {
+			actual := Strftime(row.Pattern, tm)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestStrftime_HistoricalOffset(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no zoneinfo: %v", err)
+	}
+	// Paris kept local mean time until 1891.
+	tm := time.Date(1890, 6, 1, 12, 0, 0, 0, loc)
+	if _, offset := tm.Zone(); offset != 9*60+21 {
+		t.Skipf("zoneinfo lacks Paris LMT: offset %d", offset)
+	}
+	const expect = "LMT +000921 +00:09:21"
+	if actual := Strftime("%Z %z %::z", tm); actual != expect {
+		t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+}
+
+func TestStrftime_ZoneName(t *testing.T) {
+	type testCase struct {
+		Zone   *time.Location
+		Expect string
+	}
+
+	instant := time.Unix(1136239445, 0)
+
+	testData := [...]testCase{
+		{time.UTC, "UTC"},
+		{time.FixedZone("MST", -7*60*60), "MST"},
+		{time.FixedZone("", -7*60*60), "-0700"},
+		{time.FixedZone("", 5*60*60+30*60), "+0530"},
+		{time.FixedZone("", 0), "+0000"},
+		{time.FixedZone("+03", 3*60*60), "+0300"},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Expect, func(t *testing.T) {
+			actual := Strftime("%Z", instant.In(row.Zone))
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestRegisterConversion(t *testing.T) {
+	RegisterConversion("stamp", func(buf *bytes.Buffer, t time.Time, opts Options) {
+		buf.WriteByte('[')
+		_ = StrftimeInto(buf, "%Y%m%d-%H%M", t, opts)
+		buf.WriteByte(']')
+	})
+	t.Cleanup(func() { RegisterConversion("stamp", nil) })
+
+	tm := time.Date(2023, time.October, 10, 8, 40, 39, 0, time.UTC)
+	if actual, expect := Strftime("app-%{stamp}.log", tm), "app-[20231010-0840].log"; actual != expect {
+		t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+
+	if _, err := CompilePattern("%{stamp}", Options{}); err != nil {
+		t.Errorf("CompilePattern: %v", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("prefix:")
+	if err := StrftimeInto(&buf, "%{stamp}", tm, Options{}); err != nil {
+		t.Errorf("StrftimeInto: %v", err)
+	}
+	if actual, expect := buf.String(), "prefix:[20231010-0840]"; actual != expect {
+		t.Errorf("StrftimeInto:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+
+	RegisterConversion("stamp", nil)
+	if _, err := CompilePattern("%{stamp}", Options{}); err == nil {
+		t.Errorf("CompilePattern after unregister: expected error")
+	}
+}
+
+func TestStrftime_PercentEdgeCases(t *testing.T) {
+	type testCase struct {
+		Pattern string
+		Expect  string
+		OK      bool
+	}
+
+	tm := time.Date(2023, time.October, 10, 8, 40, 39, 0, time.UTC)
+
+	testData := [...]testCase{
+		{"100%%", "100%", true},
+		{"%%%%", "%%", true},
+		{"[%5%]", "[    %]", true},
+		{"[%-5%]", "[%    ]", true},
+		{"100%", "100%", false},
+		{"%Y%", "2023%", false},
+		{"%05", "%05", false},
+		{"x%{fy", "x%{fy", false},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Pattern, func(t *testing.T) {
+			actual := Strftime(row.Pattern, tm)
+			if actual != row.Expect {
+				t.Errorf("wrong result:\n\texpect: %q\n\tactual: %q", row.Expect, actual)
+			}
+
+			_, err := CompilePattern(row.Pattern, Options{})
+			if row.OK && err != nil {
+				t.Errorf("CompilePattern: unexpected error: %v", err)
+			}
+			if !row.OK {
+				var pe *PatternError
+				if !errors.As(err, &pe) || pe.Verb != "" {
+					t.Errorf("CompilePattern: expected unterminated *PatternError, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStrftime_NumericFastPath(t *testing.T) {
+	layouts := map[string]string{
+		"%H": "15",
+		"%M": "04",
+		"%S": "05",
+		"%d": "02",
+		"%m": "01",
+		"%y": "06",
+	}
+
+	start := time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 400; i++ {
+		t0 := start.Add(time.Duration(i) * 97 * time.Minute).Add(time.Duration(i) * time.Second)
+		for pattern, layout := range layouts {
+			if actual, expect := Strftime(pattern, t0), t0.Format(layout); actual != expect {
+				t.Errorf("Strftime(%q, %v): expected %q, got %q", pattern, t0, expect, actual)
+			}
+		}
+	}
+
+	t1 := time.Date(2005, 3, 7, 4, 5, 6, 0, time.UTC)
+	testData := [...]struct {
+		Pattern string
+		Expect  string
+	}{
+		{"%H:%M:%S %d/%m/%y", "04:05:06 07/03/05"},
+		{"%_H|%-M|%_S", "_4|5 |_6"},
+		{"%4H|%_4d|%-4m", "0004|___7|3   "},
+		{"%1H|%0y|%+3S", "4|05|+06"},
+	}
+	for _, row := range testData {
+		if actual := Strftime(row.Pattern, t1); actual != row.Expect {
+			t.Errorf("Strftime(%q): expected %q, got %q", row.Pattern, row.Expect, actual)
+		}
+	}
+}
+
+func TestStrftime_Hour12(t *testing.T) {
+	testData := [...]struct {
+		Hour   int
+		Expect string
+	}{
+		{0, "12|12|AM"},
+		{1, "01| 1|AM"},
+		{11, "11|11|AM"},
+		{12, "12|12|PM"},
+		{13, "01| 1|PM"},
+		{23, "11|11|PM"},
+	}
+	for _, row := range testData {
+		t0 := time.Date(2023, 10, 10, row.Hour, 0, 0, 0, time.UTC)
+		if actual := Strftime("%I|%l|%p", t0); actual != row.Expect {
+			t.Errorf("Strftime(%q) at %02d:00: expected %q, got %q", "%I|%l|%p", row.Hour, row.Expect, actual)
+		}
+	}
+
+	// Every hour agrees with Go's 12-hour layouts.
+	for hour := 0; hour < 24; hour++ {
+		t0 := time.Date(2023, 10, 10, hour, 30, 0, 0, time.UTC)
+		if actual, expect := Strftime("%I %p", t0), t0.Format("03 PM"); actual != expect {
+			t.Errorf("Strftime(%q) at %02d:30: expected %q, got %q", "%I %p", hour, expect, actual)
+		}
+		if actual, expect := Strftime("%l", t0), fmt.Sprintf("%2s", t0.Format("3")); actual != expect {
+			t.Errorf("Strftime(%q) at %02d:30: expected %q, got %q", "%l", hour, expect, actual)
+		}
+	}
+}
+
+func TestStrftime_NumericZeroAlloc(t *testing.T) {
+	t0 := time.Date(2023, 10, 10, 8, 40, 39, 0, time.UTC)
+	var buf bytes.Buffer
+	for _, pattern := range []string{"%y%m%d-%H%M%S", "%_3H:%-M:%4S", "%b %e %H:%M:%S"} {
+		allocs := testing.AllocsPerRun(100, func() {
+			buf.Reset()
+			_ = StrftimeInto(&buf, pattern, t0, Options{})
+		})
+		if allocs != 0 {
+			t.Errorf("StrftimeInto(%q): expected 0 allocations, got %v", pattern, allocs)
+		}
+	}
+}
+
+func TestStrftimeInLocation(t *testing.T) {
+	utc := time.Date(2023, 10, 10, 15, 40, 39, 0, time.UTC)
+	pdt := time.FixedZone("PDT", -7*60*60)
+	jst := time.FixedZone("JST", 9*60*60)
+	const pattern = "%H %Z %z"
+
+	testData := [...]struct {
+		Loc    *time.Location
+		Expect string
+	}{
+		{nil, "15 UTC +0000"},
+		{time.UTC, "15 UTC +0000"},
+		{pdt, "08 PDT -0700"},
+		{jst, "00 JST +0900"},
+	}
+	for _, row := range testData {
+		if actual := StrftimeInLocation(pattern, utc, row.Loc); actual != row.Expect {
+			t.Errorf("StrftimeInLocation(%q, %v): expected %q, got %q", pattern, row.Loc, row.Expect, actual)
+		}
+	}
+
+	local := utc.In(pdt)
+	if actual := StrftimeInLocation("%F %H %Z", local, jst); actual != "2023-10-11 00 JST" {
+		t.Errorf("StrftimeInLocation: expected %q, got %q", "2023-10-11 00 JST", actual)
+	}
+	if local.Location() != pdt {
+		t.Errorf("StrftimeInLocation mutated its input")
+	}
+}
+
+func TestStrftime_TimeFormatAliases(t *testing.T) {
+	z1 := time.FixedZone("PDT", -7*60*60)
+	t1 := time.Unix(1696952439, 111111111).In(z1) // 2023-10-10T08:40:39.111111111-0700
+
+	testData := [...]struct {
+		Pattern string
+		Expect  string
+	}{
+		{"%{rfc3339}", "2023-10-10T08:40-07:00"},
+		{"%{rfc3339.ns}", "2023-10-10T08:40:39.111111111-07:00"},
+		{"%{kitchen.ms}", "8:40:39.111AM"},
+		{"%{Kitchen.MS}", "8:40:39.111AM"},
+		{"[%12{kitchen}]", "[      8:40AM]"},
+		{"%{unixms}", "1696952439111"},
+		{"%{date}", "Tue Oct 10 08:40:39 PDT 2023"},
+	}
+	for _, row := range testData {
+		if actual := Strftime(row.Pattern, t1); actual != row.Expect {
+			t.Errorf("Strftime(%q): expected %q, got %q", row.Pattern, row.Expect, actual)
+		}
+	}
+
+	for name, expect := range FormatAll(t1) {
+		if actual := Strftime("%{"+name+"}", t1); actual != expect {
+			t.Errorf("Strftime(%%{%s}): expected %q, got %q", name, expect, actual)
+		}
+	}
+
+	_, err := CompilePattern("%{rfc9999}", Options{})
+	var pe *PatternError
+	if !errors.As(err, &pe) {
+		t.Errorf("CompilePattern(%q): expected *PatternError, got %v", "%{rfc9999}", err)
+	}
+}
+
+func TestStrftime_ISOWeek(t *testing.T) {
+	// Jan 1-4 and Dec 28-31 are the only days whose ISO week year can
+	// differ from the calendar year.
+	var days []time.Time
+	for year := 2000; year <= 2030; year++ {
+		for day := 1; day <= 4; day++ {
+			days = append(days, time.Date(year, time.January, day, 12, 0, 0, 0, time.UTC))
+		}
+		for day := 28; day <= 31; day++ {
+			days = append(days, time.Date(year, time.December, day, 12, 0, 0, 0, time.UTC))
+		}
+	}
+
+	known := map[string]string{
+		"2005-01-01": "2004-W53 04",
+		"2008-12-29": "2009-W01 09",
+		"2021-01-03": "2020-W53 20",
+		"2024-12-30": "2025-W01 25",
+	}
+	for day, expect := range known {
+		t0, _ := time.Parse("2006-01-02", day)
+		if actual := Strftime("%G-W%V %g", t0); actual != expect {
+			t.Errorf("Strftime(%q, %s): expected %q, got %q", "%G-W%V %g", day, expect, actual)
+		}
+	}
+
+	for _, t0 := range days {
+		year, week := t0.ISOWeek()
+		expect := fmt.Sprintf("%04d-W%02d %02d", year, week, year%100)
+		if actual := Strftime("%G-W%V %g", t0); actual != expect {
+			t.Errorf("Strftime(%q, %s): expected %q, got %q", "%G-W%V %g", t0.Format("2006-01-02"), expect, actual)
+		}
+	}
+}
+
+func TestWeekOfMonth(t *testing.T) {
+	testData := [...]struct {
+		Day    string
+		Expect int
+	}{
+		{"2023-10-01", 1}, // Sunday the 1st
+		{"2023-10-07", 1},
+		{"2023-10-08", 2},
+		{"2023-10-31", 5},
+		{"2023-09-01", 1}, // Friday the 1st
+		{"2023-09-02", 1},
+		{"2023-09-03", 2},
+		{"2023-09-30", 5},
+		{"2024-03-01", 1}, // Friday the 1st, 31 days
+		{"2024-03-31", 6},
+		{"2026-02-01", 1}, // Sunday the 1st, exactly four weeks
+		{"2026-02-28", 4},
+		{"2024-02-29", 5},
+	}
+	for _, row := range testData {
+		t0, _ := time.Parse("2006-01-02", row.Day)
+		if actual := WeekOfMonth(t0); actual != row.Expect {
+			t.Errorf("WeekOfMonth(%s): expected %d, got %d", row.Day, row.Expect, actual)
+		}
+		expect := fmt.Sprintf("%02d", row.Expect)
+		if actual := Strftime("%02{wom}", t0); actual != expect {
+			t.Errorf("Strftime(%q, %s): expected %q, got %q", "%02{wom}", row.Day, expect, actual)
+		}
+	}
+
+	// Every day of a year agrees with counting Sundays directly.
+	for t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC); t0.Year() == 2023; t0 = t0.AddDate(0, 0, 1) {
+		expect := 1
+		for d := t0.AddDate(0, 0, 1-t0.Day()); d.Before(t0); d = d.AddDate(0, 0, 1) {
+			if d.Weekday() == time.Saturday {
+				expect++
+			}
+		}
+		if actual := WeekOfMonth(t0); actual != expect {
+			t.Errorf("WeekOfMonth(%s): expected %d, got %d", t0.Format("2006-01-02"), expect, actual)
+		}
+	}
+
+	if actual := Strftime("[%-3{wom}]", time.Date(2023, 10, 31, 0, 0, 0, 0, time.UTC)); actual != "[5  ]" {
+		t.Errorf("Strftime(%q): expected %q, got %q", "[%-3{wom}]", "[5  ]", actual)
+	}
+}
+
+func TestWeekOfYear(t *testing.T) {
+	// January 2024 began on a Monday.
+	testData := [...]struct {
+		Day      string
+		Saturday int
+		Monday   int
+	}{
+		{"2024-01-01", 0, 1},
+		{"2024-01-05", 0, 1},
+		{"2024-01-06", 1, 1},
+		{"2024-01-07", 1, 1},
+		{"2024-01-08", 1, 2},
+		{"2024-01-12", 1, 2},
+		{"2024-01-13", 2, 2},
+		{"2024-01-15", 2, 3},
+		{"2024-01-20", 3, 3},
+		{"2024-01-27", 4, 4},
+		{"2024-01-29", 4, 5},
+		{"2024-01-31", 4, 5},
+		{"2024-12-31", 52, 53},
+	}
+	for _, row := range testData {
+		t0, _ := time.Parse("2006-01-02", row.Day)
+		for _, start := range []struct {
+			Day    time.Weekday
+			Expect int
+		}{{time.Saturday, row.Saturday}, {time.Monday, row.Monday}} {
+			if actual := WeekOfYear(t0, start.Day); actual != start.Expect {
+				t.Errorf("WeekOfYear(%s, %v): expected %d, got %d", row.Day, start.Day, start.Expect, actual)
+			}
+			expect := fmt.Sprintf("%02d", start.Expect)
+			if actual := StrftimeWithOptions("%{week}", t0, Options{WeekStart: start.Day}); actual != expect {
+				t.Errorf("StrftimeWithOptions(%q, %s, %v): expected %q, got %q", "%{week}", row.Day, start.Day, expect, actual)
+			}
+		}
+	}
+
+	// Every day of a year agrees with counting start days directly.
+	for start := time.Sunday; start <= time.Saturday; start++ {
+		for t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC); t0.Year() == 2023; t0 = t0.AddDate(0, 0, 1) {
+			expect := 0
+			for d := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC); !d.After(t0); d = d.AddDate(0, 0, 1) {
+				if d.Weekday() == start {
+					expect++
+				}
+			}
+			if actual := WeekOfYear(t0, start); actual != expect {
+				t.Errorf("WeekOfYear(%s, %v): expected %d, got %d", t0.Format("2006-01-02"), start, expect, actual)
+			}
+		}
+	}
+
+	if _, err := CompilePattern("%{week}", Options{WeekStart: 7}); err == nil {
+		t.Errorf("CompilePattern: expected error for WeekStart 7")
+	}
+}
+
+func TestStrftime_NoPanic(t *testing.T) {
+	// Years outside 0-9999 used to round-trip through t.Format and
+	// strconv.ParseUint, which panicked on the minus sign.
+	tm := time.Date(-44, time.March, 15, 13, 0, 0, 0, time.UTC)
+	if actual, expect := Strftime("%Y|%C|%y|%I", tm), "-044|-1|56|01"; actual != expect {
+		t.Errorf("negative year:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+	tm = time.Date(12345, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if actual, expect := Strftime("%Y|%C|%y|%I", tm), "12345|123|45|12"; actual != expect {
+		t.Errorf("five-digit year:\n\texpect: %q\n\tactual: %q", expect, actual)
+	}
+
+	RegisterConversion("boom", func(buf *bytes.Buffer, t time.Time, opts Options) {
+		buf.WriteString("partial")
+		panic("boom")
+	})
+	t.Cleanup(func() { RegisterConversion("boom", nil) })
+
+	var buf bytes.Buffer
+	err := StrftimeInto(&buf, "a-%{boom}-b", tm, Options{})
+	var pe *PatternError
+	if !errors.As(err, &pe) || pe.Verb != "{boom}" {
+		t.Errorf("expected *PatternError for {boom}, got %v", err)
+	}
+	if actual := buf.String(); strings.Contains(actual, "partial") || !strings.HasPrefix(actual, "a-%!ERR[") || !strings.HasSuffix(actual, "-b") {
+		t.Errorf("unexpected output %q", actual)
+	}
+}
+
+func BenchmarkStrftime_Numeric(b *testing.B) {
+	t0 := time.Date(2023, 10, 10, 8, 40, 39, 111111111, time.UTC)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		_ = StrftimeInto(&buf, "%y%m%d-%H%M%S", t0, Options{})
+	}
+}
+
+func BenchmarkStrftime_NumericWidth(b *testing.B) {
+	t0 := time.Date(2023, 10, 10, 8, 40, 39, 111111111, time.UTC)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		_ = StrftimeInto(&buf, "%_3H:%-M:%4S", t0, Options{})
+	}
+}
+
+func BenchmarkStrftime_Verbs(b *testing.B) {
+	t0 := time.Date(2023, 10, 10, 8, 40, 39, 111111111, time.FixedZone("PDT", -7*60*60))
+
+	type testCase struct {
+		Name    string
+		Pattern string
+	}
+
+	testData := [...]testCase{
+		{"Text", "%a %A %b %B %p"},
+		{"TwoDigit", "%d %m %H %M %S"},
+		{"Year", "%Y %y %G %C"},
+		{"Zone", "%z %:z %Z"},
+		{"Epoch", "%s %.3s %.9s %{epochday}"},
+		{"LogTimestamp", "%Y-%m-%dT%H:%M:%S%:z"},
+		{"Syslog", "%b %e %H:%M:%S"},
+	}
+
+	for _, row := range testData {
+		b.Run(row.Name, func(b *testing.B) {
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				_ = StrftimeInto(&buf, row.Pattern, t0, Options{})
+			}
+		})
+	}
+
+	b.Run("Compiled", func(b *testing.B) {
+		cp, err := CompilePattern("%Y-%m-%dT%H:%M:%S%:z", Options{})
+		if err != nil {
+			b.Fatalf("CompilePattern: %v", err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = cp.Format(t0)
+		}
+	})
+}
+
+func TestStrftime_ZeroFlag(t *testing.T) {
+	tm := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	type testCase struct {
+		Pattern string
+		Expect  string
+	}
+
+	testData := [...]testCase{
+		{"[%0A]", "[Monday]"},
+		{"[%00A]", "[Monday]"},
+		{"[%05A]", "[Monday]"},
+		{"[%010A]", "[0000Monday]"},
+		{"[%0010A]", "[0000Monday]"},
+		{"[%0d]", "[02]"},
+		{"[%00d]", "[02]"},
+		{"[%05d]", "[00002]"},
+		{"[%0_5d]", "[____2]"},
+	}
+
+	for _, row := range testData {
+		if actual := Strftime(row.Pattern, tm); actual != row.Expect {
+			t.Errorf("Strftime(%q):\n\texpect: %q\n\tactual: %q", row.Pattern, row.Expect, actual)
+		}
+	}
+}
+
+func TestStrftime_WidthCap(t *testing.T) {
+	tm := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	type testCase struct {
+		Pattern string
+		Length  int
+	}
+
+	testData := [...]testCase{
+		{"%999999999A", defaultMaxWidth},
+		{"%99999999999999999999999999d", defaultMaxWidth},
+		{"%-18446744073709551617d", defaultMaxWidth}, // wraps a uint64 if uncapped
+		{"%.99999999999999999999999999A", len("Monday")},
+		{"%4097A%4097A", 2 * defaultMaxWidth},
+	}
+
+	for _, row := range testData {
+		actual := Strftime(row.Pattern, tm)
+		if len(actual) != row.Length {
+			t.Errorf("Strftime(%q): expected %d bytes, got %d", row.Pattern, row.Length, len(actual))
+		}
+	}
+}
+
+func TestStrftimeWithOptions_MaxWidth(t *testing.T) {
+	tm := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	type testCase struct {
+		Pattern string
+		Opts    Options
+		Expect  string
+		Err     bool
+	}
+
+	testData := [...]testCase{
+		{"[%20A]", Options{MaxWidth: 8}, "[  Monday]", false},
+		{"[%8A]", Options{MaxWidth: 8, StrictWidth: true}, "[  Monday]", false},
+		{"[%.3A]", Options{MaxWidth: 2}, "[Mo]", false},
+		{"[%9A]", Options{MaxWidth: 8, StrictWidth: true}, "[%!ERR[widthState, {8 0 0 true false false 0}, 'A']]", true},
+		{"[%.9A]", Options{MaxWidth: 8, StrictWidth: true}, "[%!ERR[precState, {0 8 0 false true false 0}, 'A']]", true},
+		{"[%9{epochday}]", Options{MaxWidth: 8, StrictWidth: true}, "[%!ERR[braceState, {8 0 0 true false false 0}, \"{epochday}\"]]", true},
+		{"[%9%]", Options{MaxWidth: 8, StrictWidth: true}, "[%!ERR[widthState, {8 0 0 true false false 0}, '%']]", true},
+		{"[%4097d]", Options{StrictWidth: true}, "[%!ERR[widthState, {4096 0 0 true false false 0}, 'd']]", true},
+	}
+
+	for _, row := range testData {
+		if actual := StrftimeWithOptions(row.Pattern, tm, row.Opts); actual != row.Expect {
+			t.Errorf("StrftimeWithOptions(%q, %+v):\n\texpect: %q\n\tactual: %q", row.Pattern, row.Opts, row.Expect, actual)
+		}
+		_, err := CompilePattern(row.Pattern, row.Opts)
+		if row.Err && err == nil {
+			t.Errorf("CompilePattern(%q, %+v): expected error", row.Pattern, row.Opts)
+		} else if !row.Err && err != nil {
+			t.Errorf("CompilePattern(%q, %+v): unexpected error: %v", row.Pattern, row.Opts, err)
+		}
+	}
+
+	// A cap near the top of uint is enforced without overflowing: 2^64
+	// would wrap to a width of 0.
+	huge := Options{MaxWidth: ^uint(0), StrictWidth: true}
+	if _, err := CompilePattern("%18446744073709551616A", huge); err == nil {
+		t.Errorf("CompilePattern(%q, MaxWidth: max uint): expected error", "%18446744073709551616A")
+	}
+	if actual := StrftimeWithOptions("%.18446744073709551616A", tm, Options{MaxWidth: ^uint(0)}); actual != "Monday" {
+		t.Errorf("expected %q, got %q", "Monday", actual)
+	}
+
+	// Clamping bounds the output, however absurd the width.
+	if actual := StrftimeWithOptions("%999999999999A", tm, Options{MaxWidth: 16}); len(actual) != 16 {
+		t.Errorf("expected 16 bytes, got %d", len(actual))
+	}
+}
+
+func FuzzStrftime(f *testing.F) {
+	for _, pattern := range []string{
+		"%Y-%m-%dT%H:%M:%S%z",
+		"%a %b %e %H:%M:%S %Z %Y",
+		"%_10A|%-5d|%010.3B|%#p",
+		"%:::z %{ago} %{fy} %{epochday} %{counter:fuzz}",
+		"%5.2{rfc3339.ms}%%%n%t",
+		"%99999999999999999999d",
+		"%.99999999999999999999S",
+		"%{",
+		"%",
+	} {
+		f.Add(pattern)
+	}
+
+	times := []time.Time{
+		time.Date(2006, 1, 2, 15, 4, 5, 999999999, time.FixedZone("MST", -7*60*60)),
+		time.Date(-1, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Unix(1<<40, 0).In(time.FixedZone("LMT", 9*60+21)),
+	}
+
+	f.Fuzz(func(t *testing.T, pattern string) {
+		for _, tm := range times {
+			out := Strftime(pattern, tm)
+			if len(out) > len(pattern)*(defaultMaxWidth+64)+64 {
+				t.Fatalf("Strftime(%q): output of %d bytes", pattern, len(out))
+			}
+		}
+	})
+}